@@ -14,10 +14,10 @@ import (
 	"encoding/xml"
 	"fmt"
 	"github.com/devicehive/IoT-framework/devicehive-alljoyn/ajmarshal"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
 	"io"
-	"log"
 	"strings"
 	"unsafe"
 	"flag"
@@ -97,6 +97,14 @@ type AllJoynMessenger struct {
 	binding     []*AllJoynBindingInfo
 }
 
+var (
+	logFormat = "logfmt"
+	logLevel  = "info"
+
+	ajLog      = log.Component("alljoyn")
+	signalsLog = log.Component("signals")
+)
+
 var (
 	spawnUUID      = ""
 	spawnDbusServiceId = ""
@@ -107,6 +115,8 @@ var (
 
 // initialize test environment
 func init() {
+	flag.StringVar(&logFormat, "log-format", logFormat, "logging format: logfmt or json")
+	flag.StringVar(&logLevel, "log-level", logLevel, "logging level, may be set per component (ex: info,alljoyn=trace)")
 	flag.StringVar(&spawnUUID, "spawn-uuid", "", "(do not use directly)")
 	flag.StringVar(&spawnDbusServiceId, "spawn-dbus-service-id", "", "(do not use directly)")
 	flag.StringVar(&spawnDbusService, "spawn-dbus-service", "", "(do not use directly)")
@@ -283,7 +293,7 @@ func GetAllJoynObjects(objects []*AllJoynBindingInfo) *C.AJ_Object {
 	for i, object := range objects {
 		interfaces = ParseAllJoynInterfaces(object.introspectData.Interfaces)
 		flags := getAllJoynObjectFlags(object)
-		log.Printf("Creating Object %s %b", object.introspectData.Name, flags)
+		ajLog.Debugf("Creating Object %s %b", object.introspectData.Name, flags)
 		C.Create_AJ_Object(C.uint32_t(i), array, C.CString(object.introspectData.Name), &interfaces[0], flags, unsafe.Pointer(nil))
 	}
 	C.Create_AJ_Object(C.uint32_t(len(objects)+1), array, nil, nil, 0, nil)
@@ -311,13 +321,13 @@ func GetAllJoynObjects(objects []*AllJoynBindingInfo) *C.AJ_Object {
 //}
 
 func (m *AllJoynMessenger) callRemoteMethod(message *C.AJ_Message, path, member string, arguments []interface{}) (err error) {
-	log.Printf("MSG: message.hdr=%p", message.hdr)
+	ajLog.Tracef("MSG: message.hdr=%p", message.hdr)
 	remote := m.bus.Object(m.dbusService, dbus.ObjectPath(path))
 	// log.Printf("%s Argument[0] %+v", member, reflect.ValueOf(arguments[0]).Type())
 	res := remote.Call(member, 0, arguments...)
 
 	if res.Err != nil {
-		log.Printf("Error calling dbus method (%s): %s", member, res.Err)
+		ajLog.Warnf("Error calling dbus method (%s): %s", member, res.Err)
 		return res.Err
 	}
 
@@ -330,7 +340,7 @@ func (m *AllJoynMessenger) callRemoteMethod(message *C.AJ_Message, path, member
 	pad, err := enc.Encode(res.Body...)
 	// log.Printf("Padding of the encoded buffer: %d", pad)
 	if err != nil {
-		log.Printf("Error encoding result: %s", err)
+		ajLog.Warnf("Error encoding result: %s", err)
 		return err
 	}
 	/*
@@ -353,9 +363,9 @@ func (m *AllJoynMessenger) callRemoteMethod(message *C.AJ_Message, path, member
 	//	}
 
 	if len(newBuf) > 0 {
-		log.Printf("MSG: message.hdr=%p", message.hdr)
+		ajLog.Tracef("MSG: message.hdr=%p", message.hdr)
 		C.AJ_DeliverMsgPartial((*C.AJ_Message)(message), C.uint32_t(len(newBuf)))
-		log.Printf("MSG: message.hdr=%p", message.hdr)
+		ajLog.Tracef("MSG: message.hdr=%p", message.hdr)
 
 		C.AJ_MarshalRaw((*C.AJ_Message)(message), unsafe.Pointer(&newBuf[0]), C.size_t(len(newBuf)))
 	} else {
@@ -366,7 +376,7 @@ func (m *AllJoynMessenger) callRemoteMethod(message *C.AJ_Message, path, member
 	//		message.hdr = hdr
 	//		message.hdr.flags &= ^C.uint8_t(C.AJ_FLAG_ENCRYPTED)
 	//	}
-	log.Printf("MSG: message.hdr=%p", message.hdr)
+	ajLog.Tracef("MSG: message.hdr=%p", message.hdr)
 	return nil
 }
 
@@ -391,7 +401,8 @@ func dumpMessage() string {
 }
 
 func (m *AllJoynMessenger) forwardAllJoynMessage(msgId uint32) (err error) {
-	log.Printf("Passing msgId 0x%x to DBus", msgId)
+	clog := ajLog.With("msgId", fmt.Sprintf("0x%x", msgId))
+	clog.Debugf("Passing message to DBus")
 	msg := C.Get_AJ_Message()
 	reply := C.Get_AJ_ReplyMessage()
 
@@ -407,14 +418,14 @@ func (m *AllJoynMessenger) forwardAllJoynMessage(msgId uint32) (err error) {
 			b = append(b, C.GoBytes(unsafe.Pointer(data), C.int(actual))...)
 			// log.Printf("Reading RAW message, status = %d, actual = %d", status, actual)
 		} else {
-			log.Printf("Error while reading message body, status = %d", status)
+			clog.Warnf("Error while reading message body, status = %d", status)
 			break
 		}
 	}
 	s, err := dbus.ParseSignature(signature)
 
 	if err != nil {
-		log.Printf("Error parsing signature: %s", err)
+		clog.Warnf("Error parsing signature: %s", err)
 		return err
 	}
 
@@ -422,7 +433,7 @@ func (m *AllJoynMessenger) forwardAllJoynMessage(msgId uint32) (err error) {
 	res, err := d.Decode(s)
 
 	if err != nil {
-		log.Printf("Error decoding message [%+v] : %s", b, err)
+		clog.Warnf("Error decoding message [%+v] : %s", b, err)
 		return err
 	}
 
@@ -433,11 +444,11 @@ func (m *AllJoynMessenger) forwardAllJoynMessage(msgId uint32) (err error) {
 	destination := C.GoString(C.Get_AJ_Message_destination())
 	iface := C.GoString(C.Get_AJ_Message_iface())
 
-	log.Printf("Message [objPath, member, iface, destination]: %s, %s, %s, %s", objPath, member, iface, destination)
+	clog.Debugf("Message [objPath, member, iface, destination]: %s, %s, %s, %s", objPath, member, iface, destination)
 
 	for _, service := range m.binding {
 		if service.allJoynPath == objPath {
-			log.Printf("Found matching dbus service: %+v", service)
+			clog.Debugf("Found matching dbus service: %+v", service)
 			C.AJ_MarshalReplyMsg((*C.AJ_Message)(msg), (*C.AJ_Message)(reply))
 			m.callRemoteMethod((*C.AJ_Message)(reply), service.dbusPath, iface+"."+member, res)
 			C.AJ_DeliverMsg((*C.AJ_Message)(reply))
@@ -496,11 +507,11 @@ func (a *AllJoynBridge) findSignal(signal *dbus.Signal) (*introspect.Signal, uin
 	singalInterface := signal.Name[:sepPos]
 	singalName := signal.Name[sepPos+1:]
 
-	log.Printf("**** Inteface: %s, Name: %s", singalInterface, singalName)
+	signalsLog.Tracef("Inteface: %s, Name: %s", singalInterface, singalName)
 
 	for _, service := range a.services {
 		if service.dbusService == signal.Sender {
-			log.Printf("Found matching service: %s", service.dbusServiceName)
+			signalsLog.Debugf("Found matching service: %s", service.dbusServiceName)
 
 			// using registeredObjects here as index is calculated for registered alljoyn objects
 			for objIdx, object := range service.registeredObjects {
@@ -512,7 +523,7 @@ func (a *AllJoynBridge) findSignal(signal *dbus.Signal) (*introspect.Signal, uin
 
 									memberIdx := len(iface.Methods) + sIdx
 									signalMessageId := 0x01000000 | (uint32(objIdx) << 16) | (uint32(ifIdx) << 8) | uint32(memberIdx)
-									log.Printf("##### Signal: %s => 0x%X  (%d %d %d)", signal.Name, signalMessageId, objIdx, ifIdx, memberIdx)
+									signalsLog.Tracef("Signal: %s => 0x%X  (%d %d %d)", signal.Name, signalMessageId, objIdx, ifIdx, memberIdx)
 
 									// cache value for future use
 									a.signalCache[key] = &sgn
@@ -544,7 +555,7 @@ func parseNotificationBody(body []interface{}) (msgType uint16, lang string, msg
 	if data, ok := body[2].(uint16); ok {
 		msgType = data
 	} else {
-		log.Printf("Could not parse msgType: %+v", body[2])
+		signalsLog.Warnf("Could not parse msgType: %+v", body[2])
 		success = success && false
 	}
 
@@ -554,7 +565,7 @@ func parseNotificationBody(body []interface{}) (msgType uint16, lang string, msg
 			msg = value
 		}
 	} else {
-		log.Printf("Could not parse message: %+v", body[7])
+		signalsLog.Warnf("Could not parse message: %+v", body[7])
 		success = success && false
 	}
 
@@ -568,10 +579,10 @@ func (a *AllJoynBridge) processNotificationSignal(signal *dbus.Signal) bool {
 	}
 
 	if msgType, lang, message, ok := parseNotificationBody(signal.Body); ok {
-		log.Printf("NOTIFY: %v => [%s:%s]", msgType, lang, message)
+		signalsLog.Debugf("NOTIFY: %v => [%s:%s]", msgType, lang, message)
 		C.SendNotification(C.uint16_t(msgType), C.CString(lang), C.CString(message))
 	} else {
-		log.Printf("ERROR PARSING NOTIFY BODY: %+v", signal.Body)
+		signalsLog.Warnf("Error parsing notification body: %+v", signal.Body)
 	}
 
 	return true
@@ -588,7 +599,7 @@ func (a *AllJoynBridge) processSignals() {
 	// log.Printf("Processing signals: %d", len(signals))
 
 	for signal := range signals {
-		log.Printf("**** Incoming Signal: %+v", signal)
+		signalsLog.Tracef("Incoming Signal: %+v", signal)
 
 		if a.processNotificationSignal(signal) {
 			continue
@@ -598,18 +609,19 @@ func (a *AllJoynBridge) processSignals() {
 		sigIntrospect, msgId := a.findSignal(signal)
 
 		if msgId == 0 {
-			log.Printf("Could not find any matching service for signal: %+v", signal)
+			signalsLog.Warnf("Could not find any matching service for signal: %+v", signal)
 			continue
 		}
-		log.Printf("%v", sigIntrospect)
+		clog := signalsLog.With("msgId", fmt.Sprintf("0x%x", msgId))
+		clog.Tracef("Signal introspection: %v", sigIntrospect)
 		if isSessionless(sigIntrospect) {
-			log.Printf("SESSIONLESS SIGNAL!")
+			clog.Tracef("Sessionless signal")
 
 			var status C.AJ_Status = C.AJ_OK
 			msg := C.Get_AJ_Message()
 
 			status = C.AJ_MarshalSignal_cgo(msg, C.uint32_t(msgId), C.uint32_t(0), C.AJ_FLAG_SESSIONLESS, C.uint32_t(0))
-			log.Printf("**** AJ_MarshalSignal: %s", status)
+			clog.Tracef("AJ_MarshalSignal: %s", status)
 
 			if len(signal.Body) > 0 {
 
@@ -619,7 +631,7 @@ func (a *AllJoynBridge) processSignals() {
 				pad, err := enc.Encode(signal.Body...)
 
 				if err != nil {
-					log.Printf("Error encoding result: %s", err)
+					clog.Warnf("Error encoding result: %s", err)
 					continue
 				}
 
@@ -627,20 +639,20 @@ func (a *AllJoynBridge) processSignals() {
 
 				if len(newBuf) > 0 {
 					status = C.AJ_DeliverMsgPartial((*C.AJ_Message)(msg), C.uint32_t(len(newBuf)))
-					log.Printf("**** AJ_DeliverMsgPartial: %s", status)
+					clog.Tracef("AJ_DeliverMsgPartial: %s", status)
 					status = C.AJ_MarshalRaw((*C.AJ_Message)(msg), unsafe.Pointer(&newBuf[0]), C.size_t(len(newBuf)))
 				} else {
 					status = C.AJ_MarshalRaw((*C.AJ_Message)(msg), unsafe.Pointer(&newBuf), C.size_t(0))
 				}
-				log.Printf("**** AJ_MarshalRaw: %s", status)
+				clog.Tracef("AJ_MarshalRaw: %s", status)
 
 			}
 
 			status = C.AJ_DeliverMsg((*C.AJ_Message)(msg))
-			log.Printf("**** AJ_DeliverMsg: %s", status)
+			clog.Tracef("AJ_DeliverMsg: %s", status)
 
 			status = C.AJ_CloseMsg((*C.AJ_Message)(msg))
-			log.Printf("**** AJ_CloseMsg: %s", status)
+			clog.Tracef("AJ_CloseMsg: %s", status)
 
 		} else {
 
@@ -650,7 +662,7 @@ func (a *AllJoynBridge) processSignals() {
 				msg := C.Get_AJ_Message()
 
 				status = C.AJ_MarshalSignal_cgo(msg, C.uint32_t(msgId), C.uint32_t(sessionId), C.uint8_t(0), C.uint32_t(0))
-				log.Printf("**** AJ_MarshalSignal: %s", status)
+				clog.Tracef("AJ_MarshalSignal: %s", status)
 
 				if len(signal.Body) > 0 {
 
@@ -660,7 +672,7 @@ func (a *AllJoynBridge) processSignals() {
 					pad, err := enc.Encode(signal.Body...)
 
 					if err != nil {
-						log.Printf("Error encoding result: %s", err)
+						clog.Warnf("Error encoding result: %s", err)
 						continue
 					}
 
@@ -668,20 +680,20 @@ func (a *AllJoynBridge) processSignals() {
 
 					if len(newBuf) > 0 {
 						status = C.AJ_DeliverMsgPartial((*C.AJ_Message)(msg), C.uint32_t(len(newBuf)))
-						log.Printf("**** AJ_DeliverMsgPartial: %s", status)
+						clog.Tracef("AJ_DeliverMsgPartial: %s", status)
 						status = C.AJ_MarshalRaw((*C.AJ_Message)(msg), unsafe.Pointer(&newBuf[0]), C.size_t(len(newBuf)))
 					} else {
 						status = C.AJ_MarshalRaw((*C.AJ_Message)(msg), unsafe.Pointer(&newBuf), C.size_t(0))
 					}
-					log.Printf("**** AJ_MarshalRaw: %s", status)
+					clog.Tracef("AJ_MarshalRaw: %s", status)
 
 				}
 
 				status = C.AJ_DeliverMsg((*C.AJ_Message)(msg))
-				log.Printf("**** AJ_DeliverMsg: %s", status)
+				clog.Tracef("AJ_DeliverMsg: %s", status)
 
 				status = C.AJ_CloseMsg((*C.AJ_Message)(msg))
-				log.Printf("**** AJ_CloseMsg: %s", status)
+				clog.Tracef("AJ_CloseMsg: %s", status)
 			}
 		}
 	}
@@ -691,7 +703,7 @@ func (a *AllJoynBridge) fetchAboutData(svcInfo *AllJoynServiceInfo, objInfo *All
 	obj := a.bus.Object(svcInfo.dbusService, dbus.ObjectPath(objInfo.dbusPath))
 	call := obj.Call(AJ_ABOUT_GETABOUTDATA, 0, "en")
 	if call.Err != nil {
-		log.Printf("Error calling %s: %v", AJ_ABOUT_GETABOUTDATA, call.Err)
+		log.Warnf("Error calling %s: %v", AJ_ABOUT_GETABOUTDATA, call.Err)
 		return call.Err
 	}
 
@@ -699,7 +711,7 @@ func (a *AllJoynBridge) fetchAboutData(svcInfo *AllJoynServiceInfo, objInfo *All
 
 	call.Store(&aboutData)
 
-	log.Printf("ABOUT: %+v", aboutData)
+	log.Debugf("ABOUT: %+v", aboutData)
 
 	for key, value := range aboutData {
 		//		log.Printf("%s(%s)", key, value.Signature())
@@ -735,7 +747,7 @@ func (service *AllJoynServiceInfo) MemberDescriptionProvider(objIdx int, ifIdx i
 
 	// zero position means obejct/interface/member own description rather than child position
 	if ifIdx == 0 {
-		log.Printf("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, obj.Name)
+		ajLog.Tracef("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, obj.Name)
 		return obj.Name
 	}
 
@@ -743,7 +755,7 @@ func (service *AllJoynServiceInfo) MemberDescriptionProvider(objIdx int, ifIdx i
 	methods, signals, properties := len(iface.Methods), len(iface.Signals), len(iface.Properties)
 
 	if memberIdx == 0 {
-		log.Printf("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, iface.Name)
+		ajLog.Tracef("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, iface.Name)
 		return iface.Name
 	} else {
 		memberIdx = memberIdx - 1
@@ -769,7 +781,7 @@ func (service *AllJoynServiceInfo) MemberDescriptionProvider(objIdx int, ifIdx i
 		name = fmt.Sprintf("Unknown %v, %v, %v ,%v", objIdx, ifIdx, memberIdx, argIdx)
 	}
 
-	log.Printf("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, name)
+	ajLog.Tracef("MEMBER DESC (%v, %v, %v ,%v) => %s", objIdx, ifIdx, memberIdx, argIdx, name)
 
 	return name
 }
@@ -807,19 +819,19 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 	// C.AJ_PrintXMLWithDescriptions(objects, C.CString("en"))
 
 	if notificationsObj != nil {
-		log.Println("NOTIFICATIONS ENABLED")
+		ajLog.Infof("Notifications enabled")
 		//		C.InitNotificationContent()
 		status = C.AJNS_Producer_Start()
 	}
 
 	if status != C.AJ_OK {
-		log.Printf("Error: AJNS_Producer_Start()=> %s", status)
+		ajLog.Warnf("Error: AJNS_Producer_Start()=> %s", status)
 	}
 
 	msg := C.Get_AJ_Message()
 	C.AJ_ClearAuthContext()
 
-	log.Printf("CreateAJ_BusAttachment(): %+v", busAttachment)
+	ajLog.Debugf("CreateAJ_BusAttachment(): %+v", busAttachment)
 
 	go func() {
 		for {
@@ -838,7 +850,7 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 					(*C.AJ_SessionOpts)(C.Get_Session_Opts()),
 				)
 
-				log.Printf("StartService returned %s", status)
+				ajLog.Infof("StartService returned %s", status)
 
 				if status != C.AJ_OK {
 
@@ -852,7 +864,7 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 					AJ_CP_PORT, (*C.AJ_SessionOpts)(C.Get_Session_Opts()), 0)
 
 				if status != C.AJ_OK {
-					log.Printf(("Failed to send bind control panel port message"))
+					ajLog.Warnf("Failed to send bind control panel port message")
 				}
 
 			}
@@ -865,13 +877,13 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 				continue
 			}
 
-			log.Printf("----------------------------")
-			log.Printf("AJ_UnmarshalMsg: %s", status)
+			ajLog.Tracef("AJ_UnmarshalMsg: %s", status)
 
 			if C.AJ_OK == status {
 
 				msgId := C.Get_AJ_Message_msgId()
-				log.Printf("MSG ID: 0x%X\n%s", msgId, dumpMessage())
+				clog := ajLog.With("msgId", fmt.Sprintf("0x%x", uint32(msgId)))
+				clog.Debugf("Message received:\n%s", dumpMessage())
 
 				switch {
 				case msgId == C.AJ_METHOD_ACCEPT_SESSION:
@@ -887,11 +899,11 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 
 						if port == PORT || port == AJ_CP_PORT {
 							status = C.AJ_BusReplyAcceptSession((*C.AJ_Message)(msg), C.TRUE)
-							log.Printf("ACCEPT_SESSION: %d at %d port", sessionId, port)
+							clog.Infof("ACCEPT_SESSION: %d at %d port", sessionId, port)
 							a.addSession(sessionId)
 						} else {
 							status = C.AJ_BusReplyAcceptSession((*C.AJ_Message)(msg), C.FALSE)
-							log.Printf("REJECT_SESSION: %d at %d port", sessionId, port)
+							clog.Infof("REJECT_SESSION: %d at %d port", sessionId, port)
 						}
 					}
 
@@ -903,7 +915,7 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 
 						sessionId := uint32(c_sessionId)
 						reason := uint32(c_reason)
-						log.Printf("Session lost: %d as of reason %d", sessionId, reason)
+						clog.Infof("Session lost: %d as of reason %d", sessionId, reason)
 						a.removeSession(sessionId)
 					}
 					//				case uint32(msgId) == 0x1010003: // Config.GetConfigurations
@@ -927,22 +939,22 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 
 					if (uint32(msgId) & 0xFFFF0000) == 0x00050000 {
 						if uint32(msgId) == 0x00050102 {
-							log.Printf("Passing About.GetObjectDescription %+v to AllJoyn", msgId)
+							clog.Debugf("Passing About.GetObjectDescription to AllJoyn")
 							status = C.AJ_BusHandleBusMessage((*C.AJ_Message)(msg))
 						} else if uint32(msgId) == 0x00050101 {
-							log.Printf("Passing About.GetAboutData %+v to AllJoyn", msgId)
+							clog.Debugf("Passing About.GetAboutData to AllJoyn")
 							status = C.AJ_BusHandleBusMessage((*C.AJ_Message)(msg))
 						} else if uint32(msgId) == 0x00050000 {
-							log.Printf("Passing Properties.Get %+v to AllJoyn", msgId)
+							clog.Debugf("Passing Properties.Get to AllJoyn")
 							status = C.AJ_BusHandleBusMessage((*C.AJ_Message)(msg))
 						} else {
 							myMessenger.forwardAllJoynMessage(uint32(msgId))
 						}
 					} else {
 						/* Pass to the built-in handlers. */
-						log.Printf("Passing msgId 0x%x to AllJoyn", uint32(msgId))
+						clog.Debugf("Passing message to AllJoyn")
 						status = C.AJ_BusHandleBusMessage((*C.AJ_Message)(msg))
-						log.Printf("AllJoyn returned %s", status)
+						clog.Debugf("AllJoyn returned %s", status)
 					}
 				}
 
@@ -960,7 +972,7 @@ func (a *AllJoynBridge) startAllJoyn(uuid string) *dbus.Error {
 
 			if status == C.AJ_ERR_READ {
 				C.AJ_Disconnect(busAttachment)
-				log.Print("AllJoyn disconnected, retrying")
+				ajLog.Warnf("AllJoyn disconnected, retrying")
 				connected = false
 				C.AJ_Sleep(1000 * 2) // TODO: Move sleep time to const
 			}
@@ -977,12 +989,12 @@ func traverseDbusObjects(bus *dbus.Conn, dbusService, dbusPath string, fn func(p
 	err := o.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xmldata)
 
 	if err != nil {
-		log.Printf("Error getting introspect from [%s, %s]: %s", dbusService, dbusPath, err)
+		log.Warnf("Error getting introspect from [%s, %s]: %s", dbusService, dbusPath, err)
 	}
 
 	err = xml.NewDecoder(strings.NewReader(xmldata)).Decode(&node)
 	if err != nil {
-		log.Printf("Error decoding introspect from [%s, %s]: %s", dbusService, dbusPath, err)
+		log.Warnf("Error decoding introspect from [%s, %s]: %s", dbusService, dbusPath, err)
 	}
 	// log.Printf("Introspect: %+v", node)
 
@@ -1002,7 +1014,7 @@ func (a *AllJoynBridge) AddService(dbusService, dbusPath, alljoynService string,
 	for {
 		uuid, err = newUUID()
 		if err != nil {
-			log.Printf("Error: %v", err)
+			log.Warnf("Error: %v", err)
 			return "", dbus.NewError("com.devicehive.Error", []interface{}{err.Error})
 		}
 
@@ -1019,7 +1031,9 @@ func (a *AllJoynBridge) AddService(dbusService, dbusPath, alljoynService string,
 			"--spawn-dbus-service-id", string(sender),
 			"--spawn-dbus-service", dbusService,
 			"--spawn-dbus-path", dbusPath,
-			"--spawn-alljoyn-service", alljoynService)
+			"--spawn-alljoyn-service", alljoynService,
+			"--log-format", logFormat,
+			"--log-level", logLevel)
 	async.Stdout = os.Stdout
 	async.Stderr = os.Stderr
 	async.Start()
@@ -1029,18 +1043,18 @@ func (a *AllJoynBridge) AddService(dbusService, dbusPath, alljoynService string,
 }
 
 func (a *AllJoynBridge) addService(uuid, dbusServiceId, dbusService, dbusPath, alljoynService string) {
-	log.Printf("Traversing objects tree for %s (%s [%s] at %s):", uuid, dbusService, dbusServiceId, dbusPath)
+	log.Infof("Traversing objects tree for %s (%s [%s] at %s):", uuid, dbusService, dbusServiceId, dbusPath)
 
 	var bindings []*AllJoynBindingInfo
 	traverseDbusObjects(a.bus, dbusServiceId, dbusPath, func(path string, node *introspect.Node) {
 		allJoynPath := strings.TrimPrefix(path, dbusPath)
 		bindings = append(bindings, &AllJoynBindingInfo{allJoynPath, path, node})
-		log.Printf("Found Object: %s with %d interfaces", allJoynPath, len(node.Interfaces))
+		log.Debugf("Found Object: %s with %d interfaces", allJoynPath, len(node.Interfaces))
 	})
 
 	a.services[uuid] = &AllJoynServiceInfo{alljoynService, dbusServiceId, dbusService, bindings, nil}
 
-	log.Printf("Added %s service with %d AJ objects", alljoynService, len(bindings))
+	log.Infof("Added %s service with %d AJ objects", alljoynService, len(bindings))
 
 	if len(bindings)!=0 {
 		go a.startAllJoyn(uuid)
@@ -1048,10 +1062,14 @@ func (a *AllJoynBridge) addService(uuid, dbusServiceId, dbusService, dbusPath, a
 }
 
 func main() {
+	if err := log.Configure("devicehive-alljoyn", logFormat, logLevel); err != nil {
+		log.Fatalf("Invalid logging options (error: %s)", err)
+	}
+
 	bus, err := dbus.SystemBus()
 
 	if err != nil {
-		log.Fatal(err)
+		log.Fatalf("Cannot connect to system bus (error: %s)", err)
 	}
 
 	// run as a child
@@ -1076,6 +1094,7 @@ func main() {
 	allJoynBridge := NewAllJoynBridge(bus)
 
 	bus.Export(allJoynBridge, "/com/devicehive/alljoyn/bridge", "com.devicehive.alljoyn.bridge")
+	log.Export(bus, "/com/devicehive/alljoyn/bridge")
 
 	n := &introspect.Node{
 		Interfaces: []introspect.Interface{
@@ -1084,6 +1103,7 @@ func main() {
 				Methods: introspect.Methods(allJoynBridge),
 				Signals: []introspect.Signal{},
 			},
+			log.Introspection(),
 		},
 	}
 
@@ -1098,7 +1118,7 @@ func main() {
 	bus.Export(introspect.NewIntrospectable(n), "/com/devicehive/alljoyn/bridge", "org.freedesktop.DBus.Introspectable")
 	bus.Export(introspect.NewIntrospectable(root), "/", "org.freedesktop.DBus.Introspectable") // workaroud for dbus issue #14
 
-	log.Printf("Bridge is Running.")
+	log.Infof("Bridge is Running.")
 
 	select {}
 }
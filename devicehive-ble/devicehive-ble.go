@@ -4,9 +4,10 @@ package main
 
 import (
 	"encoding/hex"
+	"flag"
 	"fmt"
-	"log"
 
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
 	"github.com/devicehive/gatt"
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
@@ -21,6 +22,14 @@ const (
 	ExploreTimeout    = 5 // Timeout to explore peripherals for a newly found device
 )
 
+var (
+	logFormat = flag.String("log-format", "logfmt", "logging format: logfmt or json")
+	logLevel  = flag.String("log-level", "info", "logging level, may be set per component (ex: info,gatt=debug)")
+
+	hciLog  = log.Component("hci")
+	gattLog = log.Component("gatt")
+)
+
 type BleDbusWrapper struct {
 	bus                   *dbus.Conn
 	device                gatt.Device
@@ -72,7 +81,7 @@ func NewBleDbusWrapper(bus *dbus.Conn) *BleDbusWrapper {
 	}...)
 
 	if err != nil {
-		log.Fatalf("Failed to open device, err: %s\n", err)
+		log.Fatalf("Failed to open device (error: %s)", err)
 		return nil
 	}
 
@@ -95,10 +104,10 @@ func NewBleDbusWrapper(bus *dbus.Conn) *BleDbusWrapper {
 func (w *BleDbusWrapper) OnInit(dev gatt.Device, s gatt.State) {
 	switch s {
 	case gatt.StatePoweredOn:
-		log.Print("HCI device powered on")
+		hciLog.Infof("HCI device powered on")
 		w.connected = true
 	default:
-		log.Printf("StateChanged handler received: %v", s)
+		hciLog.Warnf("StateChanged handler received: %v", s)
 		w.connected = false
 	}
 }
@@ -125,7 +134,7 @@ func (w *BleDbusWrapper) OnPeripheralDisconnected(p gatt.Peripheral, err error)
 	}
 
 	if _, ok := w.devicesConnected[id]; ok {
-		log.Printf("Disconnected: %s", id)
+		hciLog.With("mac", id).Infof("Disconnected")
 		delete(w.devicesConnected, id)
 		w.emitPeripheralDisconnected(id)
 	}
@@ -150,7 +159,7 @@ func (w *BleDbusWrapper) OnPeripheralDiscovered(p gatt.Peripheral, a *gatt.Adver
 }
 
 func (w *BleDbusWrapper) emitPeripheralDiscovered(id, name string, rssi int16) {
-	log.Printf("Discovered: %s - %s (%v)", id, name, rssi)
+	hciLog.With("mac", id).Debugf("Discovered: %s (%v)", name, rssi)
 	w.bus.Emit("/com/devicehive/bluetooth", "com.devicehive.bluetooth.PeripheralDiscovered", id, name, int16(rssi))
 }
 
@@ -171,7 +180,7 @@ func (w *BleDbusWrapper) emitIndicationReceived(mac, uuid, m string) {
 }
 
 func (w *BleDbusWrapper) ScanStart() *dbus.Error {
-	log.Printf("ScanStart")
+	hciLog.Infof("ScanStart")
 	if !w.connected {
 		return newDHError("HCI is disconnected")
 	}
@@ -194,7 +203,7 @@ func (w *BleDbusWrapper) ScanStart() *dbus.Error {
 }
 
 func (w *BleDbusWrapper) ScanStop() *dbus.Error {
-	log.Printf("ScanStop")
+	hciLog.Infof("ScanStop")
 	if !w.connected {
 		return newDHError("HCI is disconnected")
 	}
@@ -204,8 +213,9 @@ func (w *BleDbusWrapper) ScanStop() *dbus.Error {
 }
 
 func (w *BleDbusWrapper) Connect(mac string, random bool) (bool, *dbus.Error) {
-	log.Printf("Connect: %s", mac)
 	mac, err := normalizeHex(mac)
+	clog := hciLog.With("mac", mac)
+	clog.Infof("Connect")
 	w.connecting = true
 	defer func() { w.connecting = false }()
 
@@ -230,13 +240,12 @@ func (w *BleDbusWrapper) Connect(mac string, random bool) (bool, *dbus.Error) {
 	}
 
 	if _, ok := w.devicesConnected[mac]; !ok {
-		log.Printf("Trying to connect: %s", mac)
+		clog.Debugf("Trying to connect")
 		w.device.StopScanning()
 		w.device.Connect(val.peripheral)
 		select {
 		case <-w.connChan:
-			id, _ := normalizeHex(mac)
-			log.Printf("PeripheralConnected: %s", id)
+			clog.Debugf("PeripheralConnected")
 			if !val.connectedOnce {
 				val.characteristics = make(map[string]*gatt.Characteristic)
 
@@ -257,7 +266,7 @@ func (w *BleDbusWrapper) Connect(mac string, random bool) (bool, *dbus.Error) {
 			val.connectedOnce = true
 			val.ready = true
 			w.emitPeripheralConnected(mac)
-			log.Printf("Connected to: %s", mac)
+			clog.Infof("Connected")
 
 		case <-time.After(ConnectionTimeout * time.Second):
 			w.device.CancelConnection(val.peripheral)
@@ -290,26 +299,27 @@ func (w *BleDbusWrapper) handleGattCommand(mac string, uuid string, message stri
 	}
 
 	res := ""
+	clog := gattLog.With("mac", mac, "uuid", uuid)
 
 	if val, ok := w.devicesDiscovered[mac]; ok {
 		if !val.ready {
-			log.Printf("Device %s is not ready (probably still connecting, or discoverig services and characteristics)", mac)
+			clog.Warnf("Device is not ready (probably still connecting, or discoverig services and characteristics)")
 			return "", newDHError("Device not ready")
 		}
 
 		if _, ok := w.devicesConnected[mac]; !ok {
-			log.Printf("handleGattCommand(): %s is not connected", mac)
+			clog.Warnf("handleGattCommand(): device is not connected")
 			return "", newDHError(fmt.Sprintf("Device [%s] not connected", mac))
 		}
 
-		log.Printf("Sending gatt command [mac: %s, char %s, message: %s]", mac, uuid, message)
+		clog.Debugf("Sending gatt command [message: %s]", message)
 		var b []byte
 		var err error
 
 		if message != "" {
 			b, err = hex.DecodeString(message)
 			if err != nil {
-				log.Printf("Invalid message: %s", message)
+				clog.Warnf("Invalid message: %s", message)
 				return "", newDHError(err.Error())
 			}
 		}
@@ -322,17 +332,17 @@ func (w *BleDbusWrapper) handleGattCommand(mac string, uuid string, message stri
 			}
 
 			if err != nil {
-				log.Printf("Error writing/reading characteristic: %s", err)
+				clog.Warnf("Error writing/reading characteristic: %s", err)
 				return "", newDHError(err.Error())
 			}
 		} else {
 			s := fmt.Sprintf("Characteristic %s not found. Please try full name and check the device spec.", uuid)
-			log.Print(s)
+			clog.Warnf("%s", s)
 			return "", newDHError(s)
 		}
 
 	} else {
-		log.Printf("Invalid peripheral ID: %s", mac)
+		clog.Warnf("Invalid peripheral ID")
 		return "", newDHError("Invalid peripheral ID")
 	}
 
@@ -381,7 +391,7 @@ func (w *BleDbusWrapper) GattNotifications(mac string, uuid string, enable bool)
 		if enable {
 			err := p.SetNotifyValue(c, func(_ *gatt.Characteristic, b []byte, e error) {
 				if e != nil {
-					log.Printf("Notification handler received error: %s", e)
+					gattLog.With("mac", mac, "uuid", uuid).Warnf("Notification handler received error: %s", e)
 					return
 				}
 
@@ -403,7 +413,7 @@ func (w *BleDbusWrapper) GattIndications(mac string, uuid string, enable bool) *
 		if enable {
 			err := p.SetIndicateValue(c, func(_ *gatt.Characteristic, b []byte, e error) {
 				if e != nil {
-					log.Printf("Indications handler received error: %s", e)
+					gattLog.With("mac", mac, "uuid", uuid).Warnf("Indications handler received error: %s", e)
 					return
 				}
 
@@ -423,7 +433,7 @@ func (w *BleDbusWrapper) GattIndications(mac string, uuid string, enable bool) *
 func (b *DiscoveredDeviceInfo) explorePeripheral(p gatt.Peripheral) error {
 	ss, err := p.DiscoverServices(nil)
 	if err != nil {
-		log.Printf("Failed to discover services, err: %s\n", err)
+		gattLog.Warnf("Failed to discover services (error: %s)", err)
 		return err
 	}
 
@@ -437,7 +447,7 @@ func (b *DiscoveredDeviceInfo) explorePeripheral(p gatt.Peripheral) error {
 		// Discovery characteristics
 		cs, err := p.DiscoverCharacteristics(nil, s)
 		if err != nil {
-			log.Printf("Failed to discover characteristics, err: %s\n", err)
+			gattLog.Warnf("Failed to discover characteristics (error: %s)", err)
 			continue
 		}
 
@@ -452,7 +462,7 @@ func (b *DiscoveredDeviceInfo) explorePeripheral(p gatt.Peripheral) error {
 			// Discovery descriptors
 			_, err := p.DiscoverDescriptors(nil, c)
 			if err != nil {
-				log.Printf("Failed to discover descriptors, err: %s\n", err)
+				gattLog.Warnf("Failed to discover descriptors (error: %s)", err)
 				continue
 			}
 
@@ -478,25 +488,31 @@ func main() {
 	// 	log.Println(http.ListenAndServe("localhost:6060", nil))
 	// }()
 
+	flag.Parse()
+	if err := log.Configure("devicehive-ble", *logFormat, *logLevel); err != nil {
+		log.Fatalf("Invalid logging options (error: %s)", err)
+	}
+
 	var err error
 	var bus *dbus.Conn
 	bus, err = dbus.SystemBus()
 	if err != nil {
-		log.Panic(err)
+		log.Fatalf("Cannot connect to system bus (error: %s)", err)
 	}
 
 	reply, err := bus.RequestName("com.devicehive.bluetooth",
 		dbus.NameFlagDoNotQueue)
 	if err != nil {
-		log.Panic(err)
+		log.Fatalf("Failed to request dbus name (error: %s)", err)
 	}
 
 	if reply != dbus.RequestNameReplyPrimaryOwner {
-		log.Fatal("name already taken")
+		log.Fatalf("name already taken")
 	}
 
 	w := NewBleDbusWrapper(bus)
 	bus.Export(w, dbus.ObjectPath("/com/devicehive/bluetooth"), "com.devicehive.bluetooth")
+	log.Export(bus, dbus.ObjectPath("/com/devicehive/bluetooth"))
 
 	// Introspectable
 	n := &introspect.Node{
@@ -527,6 +543,7 @@ func main() {
 					},
 				},
 			},
+			log.Introspection(),
 		},
 	}
 
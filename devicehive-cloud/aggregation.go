@@ -0,0 +1,165 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// notifications are aggregated and limited by device and name
+type notificationKey struct {
+	deviceId string
+	name     string
+}
+
+// summary of a numeric field
+type aggregatedField struct {
+	min, max, sum float64
+	count         uint64
+}
+
+// notifications collected during the aggregation window
+type aggregationBucket struct {
+	numeric  map[string]*aggregatedField
+	latest   map[string]interface{} // non-numeric fields
+	priority uint64
+}
+
+// collapses notifications into min/max/avg/count summaries
+type aggregator struct {
+	sync.Mutex
+	windows map[string]time.Duration // by notification name
+	buckets map[notificationKey]*aggregationBucket
+	flush   func(deviceId, name string, dat interface{}, priority uint64)
+
+	afterFunc func(d time.Duration, f func()) // schedules flushes, replaced by tests
+}
+
+// create new aggregator, flush is called at the end of each window
+func newAggregator(windows map[string]time.Duration, flush func(deviceId, name string, dat interface{}, priority uint64)) *aggregator {
+	return &aggregator{
+		windows: windows,
+		buckets: make(map[notificationKey]*aggregationBucket),
+		flush:   flush,
+
+		afterFunc: func(d time.Duration, f func()) { time.AfterFunc(d, f) },
+	}
+}
+
+// collect notification, returns false if notification is not aggregated
+func (a *aggregator) add(deviceId, name string, dat interface{}, priority uint64) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	window := a.windows[name]
+	if window <= 0 {
+		return false
+	}
+
+	key := notificationKey{deviceId: deviceId, name: name}
+	b, ok := a.buckets[key]
+	if !ok {
+		b = &aggregationBucket{
+			numeric: make(map[string]*aggregatedField),
+			latest:  make(map[string]interface{}),
+		}
+		a.buckets[key] = b
+		a.afterFunc(window, func() { a.flushBucket(key) })
+	}
+
+	if fields, ok := dat.(map[string]interface{}); ok {
+		for field, v := range fields {
+			b.addValue(field, v)
+		}
+	} else if dat != nil {
+		b.addValue("value", dat)
+	}
+	if priority > b.priority {
+		b.priority = priority
+	}
+
+	return true
+}
+
+// change aggregation windows, collected notifications are sent as scheduled
+func (a *aggregator) setWindows(windows map[string]time.Duration) {
+	a.Lock()
+	defer a.Unlock()
+	a.windows = windows
+}
+
+// send summary of the window
+func (a *aggregator) flushBucket(key notificationKey) {
+	a.Lock()
+	b := a.buckets[key]
+	delete(a.buckets, key)
+	a.Unlock()
+
+	if b != nil {
+		notificationsLog.Debugf("flushing aggregated notification(device=%q, name=%q)", key.deviceId, key.name)
+		a.flush(key.deviceId, key.name, b.summary(), b.priority)
+	}
+}
+
+// send summaries of all open windows (ex: on shutdown)
+func (a *aggregator) flushAll() {
+	a.Lock()
+	keys := make([]notificationKey, 0, len(a.buckets))
+	for key := range a.buckets {
+		keys = append(keys, key)
+	}
+	a.Unlock()
+
+	for _, key := range keys {
+		a.flushBucket(key)
+	}
+}
+
+// add field value, numbers are summarized, other values are replaced
+func (b *aggregationBucket) addValue(field string, v interface{}) {
+	x, ok := toFloat(v)
+	if !ok {
+		b.latest[field] = v
+		return
+	}
+
+	f, ok := b.numeric[field]
+	if !ok {
+		f = &aggregatedField{min: x, max: x}
+		b.numeric[field] = f
+	}
+	f.min = math.Min(f.min, x)
+	f.max = math.Max(f.max, x)
+	f.sum += x
+	f.count++
+}
+
+// build notification parameters
+func (b *aggregationBucket) summary() map[string]interface{} {
+	res := make(map[string]interface{}, len(b.numeric)+len(b.latest))
+	for field, v := range b.latest {
+		res[field] = v
+	}
+	for field, f := range b.numeric {
+		res[field] = map[string]interface{}{
+			"min":   f.min,
+			"max":   f.max,
+			"avg":   f.sum / float64(f.count),
+			"count": f.count,
+		}
+	}
+	return res
+}
+
+// get numeric value (as parsed from JSON or converted from D-Bus)
+func toFloat(v interface{}) (float64, bool) {
+	switch t := v.(type) {
+	case float64:
+		return t, true
+	case int64:
+		return float64(t), true
+	case uint64:
+		return float64(t), true
+	}
+	return 0, false
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// manually advanced clock of scheduled functions
+type fakeClock struct {
+	now    time.Duration
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Duration
+	f  func()
+}
+
+// schedule function, same as time.AfterFunc
+func (c *fakeClock) afterFunc(d time.Duration, f func()) {
+	c.timers = append(c.timers, fakeTimer{at: c.now + d, f: f})
+}
+
+// move time forward and call due functions
+func (c *fakeClock) advance(d time.Duration) {
+	c.now += d
+	var rest []fakeTimer
+	var due []func()
+	for _, t := range c.timers {
+		if t.at <= c.now {
+			due = append(due, t.f)
+		} else {
+			rest = append(rest, t)
+		}
+	}
+	c.timers = rest
+	for _, f := range due {
+		f()
+	}
+}
+
+// flushed summary
+type flushed struct {
+	deviceId string
+	name     string
+	dat      interface{}
+	priority uint64
+}
+
+func TestAggregation(t *testing.T) {
+	type add struct {
+		after    time.Duration // since the previous one
+		deviceId string
+		name     string
+		dat      interface{}
+		priority uint64
+	}
+
+	tests := []struct {
+		title      string
+		adds       []add
+		aggregated []bool
+		flushed    []flushed // in order, after the last window is over
+	}{
+		{
+			title:      "not aggregated name",
+			adds:       []add{{name: "event", dat: 1.0}},
+			aggregated: []bool{false},
+		},
+		{
+			title: "numeric fields",
+			adds: []add{
+				{name: "temp", dat: map[string]interface{}{"value": 20.0, "unit": "C"}, priority: 1},
+				{after: time.Second, name: "temp", dat: map[string]interface{}{"value": 24.0, "unit": "F"}, priority: 5},
+				{after: time.Second, name: "temp", dat: map[string]interface{}{"value": int64(22)}, priority: 2},
+			},
+			aggregated: []bool{true, true, true},
+			flushed: []flushed{{name: "temp", priority: 5, dat: map[string]interface{}{
+				"unit":  "F",
+				"value": map[string]interface{}{"min": 20.0, "max": 24.0, "avg": 22.0, "count": uint64(3)},
+			}}},
+		},
+		{
+			title: "scalar parameters",
+			adds: []add{
+				{name: "temp", dat: uint64(1)},
+				{name: "temp", dat: 3.0},
+			},
+			aggregated: []bool{true, true},
+			flushed: []flushed{{name: "temp", dat: map[string]interface{}{
+				"value": map[string]interface{}{"min": 1.0, "max": 3.0, "avg": 2.0, "count": uint64(2)},
+			}}},
+		},
+		{
+			title: "windows by device",
+			adds: []add{
+				{deviceId: "a", name: "temp", dat: 1.0},
+				{after: 5 * time.Second, deviceId: "b", name: "temp", dat: 2.0},
+			},
+			aggregated: []bool{true, true},
+			flushed: []flushed{
+				{deviceId: "a", name: "temp", dat: map[string]interface{}{
+					"value": map[string]interface{}{"min": 1.0, "max": 1.0, "avg": 1.0, "count": uint64(1)},
+				}},
+				{deviceId: "b", name: "temp", dat: map[string]interface{}{
+					"value": map[string]interface{}{"min": 2.0, "max": 2.0, "avg": 2.0, "count": uint64(1)},
+				}},
+			},
+		},
+		{
+			title: "new window after flush",
+			adds: []add{
+				{name: "temp", dat: 1.0},
+				{after: 10 * time.Second, name: "temp", dat: 5.0},
+			},
+			aggregated: []bool{true, true},
+			flushed: []flushed{
+				{name: "temp", dat: map[string]interface{}{
+					"value": map[string]interface{}{"min": 1.0, "max": 1.0, "avg": 1.0, "count": uint64(1)},
+				}},
+				{name: "temp", dat: map[string]interface{}{
+					"value": map[string]interface{}{"min": 5.0, "max": 5.0, "avg": 5.0, "count": uint64(1)},
+				}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		var res []flushed
+		clock := new(fakeClock)
+		a := newAggregator(map[string]time.Duration{"temp": 10 * time.Second}, func(deviceId, name string, dat interface{}, priority uint64) {
+			res = append(res, flushed{deviceId, name, dat, priority})
+		})
+		a.afterFunc = clock.afterFunc
+
+		for i, n := range test.adds {
+			clock.advance(n.after)
+			if ok := a.add(n.deviceId, n.name, n.dat, n.priority); ok != test.aggregated[i] {
+				t.Errorf("%s: notification #%d aggregated: %t, expected: %t", test.title, i, ok, test.aggregated[i])
+			}
+		}
+		clock.advance(time.Minute)
+
+		if !reflect.DeepEqual(res, test.flushed) {
+			t.Errorf("%s: flushed %+v, expected: %+v", test.title, res, test.flushed)
+		}
+	}
+}
+
+func TestAggregationFlushAll(t *testing.T) {
+	var res []flushed
+	clock := new(fakeClock)
+	a := newAggregator(map[string]time.Duration{"temp": time.Minute}, func(deviceId, name string, dat interface{}, priority uint64) {
+		res = append(res, flushed{deviceId, name, dat, priority})
+	})
+	a.afterFunc = clock.afterFunc
+
+	a.add("", "temp", 1.0, 0)
+	a.flushAll()
+	if len(res) != 1 {
+		t.Fatalf("flushed %+v, expected one summary", res)
+	}
+
+	// the scheduled flush has nothing to send
+	clock.advance(time.Minute)
+	if len(res) != 1 {
+		t.Errorf("flushed %+v after the window is over", res)
+	}
+}
@@ -0,0 +1,32 @@
+package backend
+
+import (
+	"time"
+
+	"github.com/devicehive/devicehive-go/devicehive/core"
+)
+
+// Backend is a cloud service used by devicehive-cloud
+// devicehive.Service satisfies this interface.
+// Backend may also implement io.Closer to release resources
+// when connection is lost (after all subscriptions are cancelled).
+type Backend interface {
+	// check the service is available, get current server timestamp
+	GetServerInfo(timeout time.Duration) (*core.ServerInfo, error)
+
+	// register (or update) the device
+	RegisterDevice(device *core.Device, timeout time.Duration) error
+
+	// send notification on behalf of the device
+	InsertNotification(device *core.Device, notification *core.Notification, timeout time.Duration) error
+
+	// subscribe device commands starting from the timestamp
+	// listener channel is closed if subscription is broken
+	SubscribeCommands(device *core.Device, timestamp string, timeout time.Duration) (*core.CommandListener, error)
+
+	// cancel command subscription of the device
+	UnsubscribeCommands(device *core.Device, timeout time.Duration) error
+
+	// update command status and result
+	UpdateCommand(device *core.Device, command *core.Command, timeout time.Duration) error
+}
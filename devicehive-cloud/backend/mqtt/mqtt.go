@@ -0,0 +1,311 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/transport"
+	"github.com/devicehive/IoT-framework/godbus-helpers/logging"
+	"github.com/devicehive/devicehive-go/devicehive/core"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// DeviceHive timestamp format
+	timestampLayout = "2006-01-02T15:04:05.000000"
+
+	// command listener buffer size
+	listenerCapacity = 64
+)
+
+var (
+	errTimeout = errors.New("MQTT operation timed out")
+
+	log = logging.Component("mqtt")
+)
+
+// notification message
+type notification struct {
+	Name       string      `json:"notification"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Timestamp  string      `json:"timestamp,omitempty"`
+}
+
+// command message (both incoming and update)
+type command struct {
+	Id         uint64      `json:"id"`
+	Name       string      `json:"command,omitempty"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Timestamp  string      `json:"timestamp,omitempty"`
+	Lifetime   uint64      `json:"lifetime,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// Backend maps DeviceHive notifications and commands onto MQTT topics
+// Connection is not restored automatically, all command listeners
+// are closed once connection is lost.
+type Backend struct {
+	config conf.MQTTConf
+	client paho.Client
+
+	mu        sync.Mutex
+	listeners []*core.CommandListener
+	lost      chan struct{} // closed when connection is lost
+}
+
+// New creates MQTT backend, connection is established on first use
+// TLS settings of the transport are used for ssl:// and wss:// brokers,
+// all brokers are connected through the proxy, transport may be nil
+func New(config conf.MQTTConf, t *transport.Transport) (*Backend, error) {
+	if len(config.Broker) == 0 {
+		return nil, errors.New("MQTT broker is not configured")
+	}
+	broker, err := url.Parse(config.Broker)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Backend{config: config, lost: make(chan struct{})}
+	opts := paho.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetAutoReconnect(false).
+		SetConnectionLostHandler(b.onConnectionLost)
+	if t != nil && t.TLS != nil {
+		opts.SetTLSConfig(t.TLS)
+	}
+	if t != nil && t.Proxy != nil {
+		switch broker.Scheme {
+		case "ws", "wss":
+			opts.SetWebsocketOptions(&paho.WebsocketOptions{Proxy: paho.ProxyFunction(t.Proxy)})
+		case "tcp", "mqtt":
+			opts.SetCustomOpenConnectionFn(func(uri *url.URL, o paho.ClientOptions) (net.Conn, error) {
+				return t.Dial(uri.Host, o.ConnectTimeout)
+			})
+		case "ssl", "tls", "mqtts", "tcps":
+			opts.SetCustomOpenConnectionFn(func(uri *url.URL, o paho.ClientOptions) (net.Conn, error) {
+				return dialTLS(t, uri, o.ConnectTimeout)
+			})
+		}
+	}
+	b.client = paho.NewClient(opts)
+	return b, nil
+}
+
+// open TLS connection through the proxy
+func dialTLS(t *transport.Transport, uri *url.URL, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.Dial(uri.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := new(tls.Config)
+	if t.TLS != nil {
+		cfg = t.TLS.Clone()
+	}
+	if len(cfg.ServerName) == 0 {
+		cfg.ServerName = uri.Hostname()
+	}
+	tlsConn := tls.Client(conn, cfg)
+	if timeout > 0 {
+		tlsConn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err = tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// Close disconnects from the broker
+func (b *Backend) Close() error {
+	b.client.Disconnect(250)
+	b.onConnectionLost(b.client, nil)
+	return nil
+}
+
+// GetServerInfo connects to the broker (if not yet connected)
+// and returns local time as server timestamp
+func (b *Backend) GetServerInfo(timeout time.Duration) (*core.ServerInfo, error) {
+	if err := b.connect(timeout); err != nil {
+		return nil, err
+	}
+
+	return &core.ServerInfo{
+		Timestamp: time.Now().UTC().Format(timestampLayout),
+	}, nil
+}
+
+// RegisterDevice publishes retained device description,
+// device and network keys are not published
+func (b *Backend) RegisterDevice(device *core.Device, timeout time.Duration) error {
+	d := *device
+	d.Key = ""
+	if d.Network != nil {
+		network := *d.Network
+		network.Key = ""
+		d.Network = &network
+	}
+	return b.publish(b.topic(b.config.DeviceTopic, device.Id, ""), &d, true, timeout)
+}
+
+// InsertNotification publishes notification
+func (b *Backend) InsertNotification(device *core.Device, n *core.Notification, timeout time.Duration) error {
+	msg := notification{
+		Name:       n.Name,
+		Parameters: n.Parameters,
+		Timestamp:  n.Timestamp,
+	}
+	if len(msg.Timestamp) == 0 {
+		msg.Timestamp = time.Now().UTC().Format(timestampLayout)
+	}
+	return b.publish(b.topic(b.config.NotificationTopic, device.Id, n.Name), msg, false, timeout)
+}
+
+// SubscribeCommands subscribes to device command topic
+// MQTT has no command history, so timestamp is ignored
+func (b *Backend) SubscribeCommands(device *core.Device, timestamp string, timeout time.Duration) (*core.CommandListener, error) {
+	if err := b.connect(timeout); err != nil {
+		return nil, err
+	}
+
+	listener := &core.CommandListener{C: make(chan *core.Command, listenerCapacity)}
+	b.mu.Lock()
+	lost := b.lost
+	select {
+	case <-lost:
+		b.mu.Unlock()
+		return nil, errors.New("MQTT connection is lost")
+	default:
+		b.listeners = append(b.listeners, listener)
+	}
+	b.mu.Unlock()
+
+	topic := b.topic(b.config.CommandTopic, device.Id, "")
+	handler := func(_ paho.Client, m paho.Message) {
+		var msg command
+		if err := json.Unmarshal(m.Payload(), &msg); err != nil {
+			log.Warnf("invalid command on %q (error: %s)", m.Topic(), err)
+			return
+		}
+
+		cmd := &core.Command{
+			Id:         msg.Id,
+			Name:       msg.Name,
+			Parameters: msg.Parameters,
+			Timestamp:  msg.Timestamp,
+			Lifetime:   msg.Lifetime,
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		select {
+		case <-lost:
+			// listener is closed
+		case listener.C <- cmd:
+		default:
+			log.Warnf("command %+v dropped (listener is full)", cmd)
+		}
+	}
+
+	token := b.client.Subscribe(topic, b.config.QoS, handler)
+	if err := wait(token, timeout); err != nil {
+		return nil, err
+	}
+	log.Debugf("subscribed to %q", topic)
+	return listener, nil
+}
+
+// UnsubscribeCommands unsubscribes from device command topic
+func (b *Backend) UnsubscribeCommands(device *core.Device, timeout time.Duration) error {
+	if !b.client.IsConnected() {
+		return nil // subscriptions are gone with the connection
+	}
+	topic := b.topic(b.config.CommandTopic, device.Id, "")
+	if err := wait(b.client.Unsubscribe(topic), timeout); err != nil {
+		return err
+	}
+	log.Debugf("unsubscribed from %q", topic)
+	return nil
+}
+
+// UpdateCommand publishes command status and result
+func (b *Backend) UpdateCommand(device *core.Device, cmd *core.Command, timeout time.Duration) error {
+	msg := command{
+		Id:     cmd.Id,
+		Name:   cmd.Name,
+		Status: cmd.Status,
+		Result: cmd.Result,
+	}
+	return b.publish(b.topic(b.config.CommandUpdateTopic, device.Id, ""), msg, false, timeout)
+}
+
+// connect to the broker if not yet connected
+func (b *Backend) connect(timeout time.Duration) error {
+	if b.client.IsConnected() {
+		return nil
+	}
+
+	log.Debugf("connecting to %q", b.config.Broker)
+	return wait(b.client.Connect(), timeout)
+}
+
+// publish JSON message
+func (b *Backend) publish(topic string, msg interface{}, retained bool, timeout time.Duration) error {
+	if err := b.connect(timeout); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	log.Tracef("publishing %s to %q", payload, topic)
+	return wait(b.client.Publish(topic, b.config.QoS, retained, payload), timeout)
+}
+
+// close all command listeners
+func (b *Backend) onConnectionLost(_ paho.Client, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.lost:
+		return // already closed
+	default:
+	}
+
+	if err != nil {
+		log.Warnf("connection is lost (error: %s)", err)
+	}
+	close(b.lost)
+	for _, listener := range b.listeners {
+		close(listener.C)
+	}
+	b.listeners = nil
+}
+
+// substitute topic placeholders
+func (b *Backend) topic(template, deviceId, name string) string {
+	return strings.NewReplacer("{device}", deviceId, "{name}", name).Replace(template)
+}
+
+// wait for MQTT operation
+func wait(token paho.Token, timeout time.Duration) error {
+	if !token.WaitTimeout(timeout) {
+		return errTimeout
+	}
+	return token.Error()
+}
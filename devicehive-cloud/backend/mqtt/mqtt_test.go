@@ -0,0 +1,316 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/transport"
+	"github.com/devicehive/devicehive-go/devicehive/core"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	broker "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+)
+
+const testTimeout = 5 * time.Second
+
+// start in-process MQTT broker, returns its URL
+func startBroker(t *testing.T) (string, func()) {
+	server := broker.New(nil)
+	if err := server.AddHook(new(auth.AllowHook), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	if err := server.AddListener(tcp); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	return "tcp://" + tcp.Address(), func() { server.Close() }
+}
+
+// connect test client
+func newClient(t *testing.T, url, id string) paho.Client {
+	c := paho.NewClient(paho.NewClientOptions().AddBroker(url).SetClientID(id))
+	if err := wait(c.Connect(), testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// create backend with default topics
+func newBackend(t *testing.T, url string) *Backend {
+	return newBackendWith(t, url, nil)
+}
+
+// create backend with default topics and the transport
+func newBackendWith(t *testing.T, url string, tr *transport.Transport) *Backend {
+	config := conf.MQTTConf{
+		Broker:             url,
+		ClientID:           "gateway",
+		QoS:                1,
+		DeviceTopic:        "devicehive/{device}/device",
+		NotificationTopic:  "devicehive/{device}/notification/{name}",
+		CommandTopic:       "devicehive/{device}/command",
+		CommandUpdateTopic: "devicehive/{device}/command/update",
+	}
+	b, err := New(config, tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = b.GetServerInfo(testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// subscribe test client to the topic
+func subscribe(t *testing.T, c paho.Client, topic string) <-chan paho.Message {
+	ch := make(chan paho.Message, 16)
+	token := c.Subscribe(topic, 1, func(_ paho.Client, m paho.Message) { ch <- m })
+	if err := wait(token, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+// receive message or fail
+func receive(t *testing.T, ch <-chan paho.Message) paho.Message {
+	select {
+	case m := <-ch:
+		return m
+	case <-time.After(testTimeout):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+func TestNotification(t *testing.T) {
+	url, stop := startBroker(t)
+	defer stop()
+
+	b := newBackend(t, url)
+	defer b.Close()
+
+	c := newClient(t, url, "app")
+	defer c.Disconnect(0)
+	ch := subscribe(t, c, "devicehive/+/notification/#")
+
+	device := &core.Device{Id: "gw"}
+	n := &core.Notification{Name: "temperature", Parameters: map[string]interface{}{"value": 21.5}}
+	if err := b.InsertNotification(device, n, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	m := receive(t, ch)
+	if m.Topic() != "devicehive/gw/notification/temperature" {
+		t.Errorf("unexpected topic %q", m.Topic())
+	}
+
+	var msg notification
+	if err := json.Unmarshal(m.Payload(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Name != "temperature" || len(msg.Timestamp) == 0 {
+		t.Errorf("unexpected notification %+v", msg)
+	}
+	if p, ok := msg.Parameters.(map[string]interface{}); !ok || p["value"] != 21.5 {
+		t.Errorf("unexpected parameters %+v", msg.Parameters)
+	}
+}
+
+func TestRegisterDevice(t *testing.T) {
+	url, stop := startBroker(t)
+	defer stop()
+
+	b := newBackend(t, url)
+	defer b.Close()
+
+	device := &core.Device{
+		Id:      "gw",
+		Name:    "gateway",
+		Key:     "device-secret",
+		Network: &core.Network{Name: "home", Key: "network-secret"},
+	}
+	if err := b.RegisterDevice(device, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	// retained message is received by later subscribers
+	c := newClient(t, url, "app")
+	defer c.Disconnect(0)
+	m := receive(t, subscribe(t, c, "devicehive/gw/device"))
+	if !m.Retained() {
+		t.Error("device description is not retained")
+	}
+
+	var d core.Device
+	if err := json.Unmarshal(m.Payload(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Name != "gateway" || d.Network == nil || d.Network.Name != "home" {
+		t.Errorf("unexpected device %s", m.Payload())
+	}
+	if len(d.Key) != 0 || len(d.Network.Key) != 0 {
+		t.Errorf("keys are published: %s", m.Payload())
+	}
+	if device.Key != "device-secret" || device.Network.Key != "network-secret" {
+		t.Error("registered device is changed")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	url, stop := startBroker(t)
+	defer stop()
+
+	b := newBackend(t, url)
+	defer b.Close()
+
+	device := &core.Device{Id: "gw"}
+	listener, err := b.SubscribeCommands(device, "", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t, url, "app")
+	defer c.Disconnect(0)
+	updates := subscribe(t, c, "devicehive/gw/command/update")
+
+	payload := `{"id": 42, "command": "switch", "parameters": {"on": true}}`
+	if err = wait(c.Publish("devicehive/gw/command", 1, false, payload), testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	var cmd *core.Command
+	select {
+	case cmd = <-listener.C:
+	case <-time.After(testTimeout):
+		t.Fatal("no command received")
+	}
+	if cmd.Id != 42 || cmd.Name != "switch" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+
+	result := &core.Command{Id: 42, Status: "success", Result: "done"}
+	if err = b.UpdateCommand(device, result, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg command
+	if err = json.Unmarshal(receive(t, updates).Payload(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Id != 42 || msg.Status != "success" || msg.Result != "done" {
+		t.Errorf("unexpected command update %+v", msg)
+	}
+}
+
+func TestConnectionLost(t *testing.T) {
+	url, stop := startBroker(t)
+
+	b := newBackend(t, url)
+	defer b.Close()
+
+	listener, err := b.SubscribeCommands(&core.Device{Id: "gw"}, "", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop()
+	select {
+	case _, ok := <-listener.C:
+		if ok {
+			t.Error("unexpected command received")
+		}
+	case <-time.After(testTimeout):
+		t.Error("listener is not closed")
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	url, stop := startBroker(t)
+	defer stop()
+
+	b := newBackend(t, url)
+	defer b.Close()
+
+	device := &core.Device{Id: "gw"}
+	listener, err := b.SubscribeCommands(device, "", testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = b.UnsubscribeCommands(device, testTimeout); err != nil {
+		t.Fatal(err)
+	}
+
+	c := newClient(t, url, "app")
+	defer c.Disconnect(0)
+	if err = wait(c.Publish("devicehive/gw/command", 1, false, `{"id": 1}`), testTimeout); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case cmd := <-listener.C:
+		t.Errorf("command %+v received after unsubscribe", cmd)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// start HTTP proxy supporting CONNECT only, counts tunnels
+func startConnectProxy(t *testing.T, tunnels chan<- string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target, err := net.Dial("tcp", r.Host)
+		if r.Method != "CONNECT" || err != nil {
+			http.Error(w, "cannot connect", http.StatusBadGateway)
+			return
+		}
+		tunnels <- r.Host
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			defer target.Close()
+			io.Copy(target, conn)
+		}()
+		defer conn.Close()
+		io.Copy(conn, target)
+	}))
+}
+
+func TestProxy(t *testing.T) {
+	brokerURL, stop := startBroker(t)
+	defer stop()
+
+	tunnels := make(chan string, 4)
+	proxy := startConnectProxy(t, tunnels)
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := transport.New(nil, func(*http.Request) (*url.URL, error) { return proxyURL, nil })
+	b := newBackendWith(t, brokerURL, tr)
+	defer b.Close()
+
+	select {
+	case host := <-tunnels:
+		if "tcp://"+host != brokerURL {
+			t.Errorf("unexpected tunnel to %q", host)
+		}
+	default:
+		t.Fatal("broker is connected directly")
+	}
+
+	if err := b.RegisterDevice(&core.Device{Id: "gw"}, testTimeout); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/dbusjson"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/history"
+	"github.com/devicehive/devicehive-go/devicehive"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	// command statuses posted by the daemon
+	commandStatusSuccess = "success"
+	commandStatusError   = "ERROR: %s"
+)
+
+// D-Bus method registered to handle a cloud command
+type commandHandler struct {
+	busName string
+	path    dbus.ObjectPath
+	method  string // fully qualified: interface.Method
+}
+
+// handlers are registered per device and command name
+type handlerKey struct {
+	deviceId string
+	command  string
+}
+
+// register D-Bus method to handle the command
+// method should be fully qualified (ex: com.example.Iface.Method)
+// it is called with (id t, name s, parameters s) arguments
+// and its return value is posted to the cloud as command result
+func (w *DBusWrapper) RegisterCommandHandler(command, busName string, path dbus.ObjectPath, method string) *dbus.Error {
+	return w.registerCommandHandler("", command, busName, path, method)
+}
+
+// unregister command handler
+func (w *DBusWrapper) UnregisterCommandHandler(command string) *dbus.Error {
+	return w.unregisterCommandHandler("", command)
+}
+
+// register D-Bus method to handle the device command
+func (d *DeviceObject) RegisterCommandHandler(command, busName string, path dbus.ObjectPath, method string) *dbus.Error {
+	return d.w.registerCommandHandler(d.id, command, busName, path, method)
+}
+
+// unregister device command handler
+func (d *DeviceObject) UnregisterCommandHandler(command string) *dbus.Error {
+	return d.w.unregisterCommandHandler(d.id, command)
+}
+
+// register command handler
+func (w *DBusWrapper) registerCommandHandler(deviceId, command, busName string, path dbus.ObjectPath, method string) *dbus.Error {
+	commandsLog.Infof("registering command handler(device=%q, command=%q, bus=%q, path=%q, method=%q)",
+		deviceId, command, busName, path, method)
+	switch {
+	case len(command) == 0:
+		return newDHError("command name is empty")
+	case len(busName) == 0:
+		return newDHError("bus name is empty")
+	case !path.IsValid():
+		return newDHError(fmt.Sprintf("invalid object path %q", path))
+	case strings.LastIndex(method, ".") <= 0:
+		return newDHError(fmt.Sprintf("method %q should be fully qualified", method))
+	}
+
+	w.handlersMutex.Lock()
+	defer w.handlersMutex.Unlock()
+	w.handlers[handlerKey{deviceId, command}] = commandHandler{
+		busName: busName,
+		path:    path,
+		method:  method,
+	}
+
+	return nil // OK
+}
+
+// unregister command handler
+func (w *DBusWrapper) unregisterCommandHandler(deviceId, command string) *dbus.Error {
+	commandsLog.Infof("unregistering command handler(device=%q, command=%q)", deviceId, command)
+
+	w.handlersMutex.Lock()
+	defer w.handlersMutex.Unlock()
+
+	key := handlerKey{deviceId, command}
+	if _, ok := w.handlers[key]; !ok {
+		return newDHError(fmt.Sprintf("no handler for command %q", command))
+	}
+	delete(w.handlers, key)
+
+	return nil // OK
+}
+
+// remove command handlers once their bus name disappears
+func (w *DBusWrapper) watchHandlerOwners() {
+	rule := "type='signal',sender='org.freedesktop.DBus',interface='org.freedesktop.DBus',member='NameOwnerChanged'"
+	call := w.bus.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule)
+	if call.Err != nil {
+		commandsLog.Warnf("Cannot watch bus names, handlers are not removed automatically (error: %s)", call.Err)
+		return
+	}
+
+	ch := make(chan *dbus.Signal, 16)
+	w.bus.Signal(ch)
+	for signal := range ch {
+		if signal.Name != "org.freedesktop.DBus.NameOwnerChanged" || len(signal.Body) != 3 {
+			continue
+		}
+		name, _ := signal.Body[0].(string)
+		newOwner, _ := signal.Body[2].(string)
+		if len(newOwner) == 0 {
+			w.removeHandlers(name)
+		}
+	}
+}
+
+// unregister all command handlers of the bus name
+func (w *DBusWrapper) removeHandlers(busName string) {
+	w.handlersMutex.Lock()
+	defer w.handlersMutex.Unlock()
+
+	for key, h := range w.handlers {
+		if h.busName == busName {
+			commandsLog.Infof("removing command handler(device=%q, command=%q), %q is gone",
+				key.deviceId, key.command, busName)
+			delete(w.handlers, key)
+		}
+	}
+}
+
+// call registered handler of the command
+// returns false if there is no handler
+func (w *DBusWrapper) dispatchCommand(cmd deviceCommand, params string) bool {
+	w.handlersMutex.RLock()
+	h, ok := w.handlers[handlerKey{cmd.DeviceId, cmd.Name}]
+	w.handlersMutex.RUnlock()
+	if !ok {
+		return false
+	}
+
+	clog := commandsLog.With("command", cmd.Id)
+	go func() {
+		clog.Debugf("calling %s.%s(%d, %q, %q) at %q", h.busName, h.method, cmd.Id, cmd.Name, params, h.path)
+		status, result := commandStatusSuccess, interface{}(nil)
+
+		ch := make(chan *dbus.Call, 1)
+		w.bus.Object(h.busName, h.path).Go(h.method, 0, ch, cmd.Id, cmd.Name, params)
+		select {
+		case call := <-ch:
+			if call.Err != nil {
+				clog.Warnf("command %q handler failed (error: %s)", cmd.Name, call.Err)
+				status = fmt.Sprintf(commandStatusError, call.Err)
+			} else {
+				result = handlerResult(call.Body)
+			}
+			if err := w.validateCommandResult(cmd.Id, result); err != nil {
+				clog.Warnf("command %q handler result is rejected (error: %s)", cmd.Name, err)
+				status, result = fmt.Sprintf(commandStatusError, err), nil
+			}
+
+		case <-time.After(w.conn.Config().CommandHandlerTimeout):
+			clog.Warnf("command %q handler timed out", cmd.Name)
+			status = fmt.Sprintf(commandStatusError, "handler timed out")
+		}
+
+		if err := w.postCommandResult(cmd.DeviceId, cmd.Id, status, result); err != nil {
+			clog.Warnf("failed to update command (error: %s)", err)
+		}
+	}()
+
+	return true
+}
+
+// mark command without handler as failed
+func (w *DBusWrapper) failUnhandledCommand(cmd deviceCommand) {
+	clog := commandsLog.With("command", cmd.Id)
+	clog.Warnf("no handler for command %q", cmd.Name)
+	status := fmt.Sprintf(commandStatusError, "command is not supported")
+	go func() {
+		if err := w.postCommandResult(cmd.DeviceId, cmd.Id, status, nil); err != nil {
+			clog.Warnf("failed to update command (error: %s)", err)
+		}
+	}()
+}
+
+// post command result to the cloud
+func (w *DBusWrapper) postCommandResult(deviceId string, id uint64, status string, result interface{}) error {
+	service, device, err := w.conn.Service(deviceId)
+	if err == errUnknownDevice {
+		w.pending.remove(id) // never will be answered
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := history.Entry{
+		Kind:   history.KindResult,
+		Device: deviceId,
+		Id:     id,
+		Status: status,
+		Result: result,
+	}
+	command := devicehive.NewCommandResult(id, status, result)
+	err = service.UpdateCommand(device, command, waitTimeout)
+	if err != nil {
+		entry.Name, _ = w.pending.name(id)
+		entry.Reason = err.Error()
+		w.record(entry)
+		return err
+	}
+
+	w.conn.touch()
+	if c, ok := w.pending.remove(id); ok {
+		latency := time.Since(c.received).Seconds()
+		commandsAnswered.WithLabelValues(metricName(c.name)).Inc()
+		commandLatency.WithLabelValues(metricName(c.name)).Observe(latency)
+		entry.Name, entry.Latency = c.name, latency
+	}
+	w.record(entry)
+	return nil
+}
+
+// convert handler's return values to command result
+// JSON strings are parsed, typed values are converted to JSON,
+// multiple values are returned as array
+func handlerResult(body []interface{}) interface{} {
+	res := make([]interface{}, 0, len(body))
+	for _, v := range body {
+		if variant, ok := v.(dbus.Variant); ok {
+			v = variant.Value()
+		}
+		if s, ok := v.(string); ok {
+			var dat interface{}
+			if json.Unmarshal([]byte(strings.Trim(s, "\x00")), &dat) == nil {
+				v = dat
+			}
+		} else if dat, err := dbusjson.ToJSON(v); err == nil {
+			v = dat
+		}
+		res = append(res, v)
+	}
+
+	switch len(res) {
+	case 0:
+		return nil
+	case 1:
+		return res[0]
+	}
+	return res
+}
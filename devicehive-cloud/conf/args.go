@@ -5,14 +5,33 @@ import "flag"
 const (
 	confArgKey          = "conf"
 	confArgDefaultValue = ""
+
+	localArgKey              = "local"
+	localAddrArgKey          = "local-addr"
+	localAddrArgDefaultValue = "127.0.0.1:8880"
+	scenarioArgKey           = "scenario"
+
+	printConfigArgKey = "print-config"
+	dumpHistoryArgKey = "dump-history"
 )
 
 var (
-	confArgValue = ""
+	confArgValue      = ""
+	localArgValue     = false
+	localAddrArgValue = ""
+	scenarioArgValue  = ""
+
+	printConfigArgValue = false
+	dumpHistoryArgValue = false
 )
 
 func init() {
 	flag.StringVar(&confArgValue, confArgKey, confArgDefaultValue, "file with DeviceHive configuration in Yaml")
+	flag.BoolVar(&localArgValue, localArgKey, false, "run embedded mock DeviceHive server (no network required)")
+	flag.StringVar(&localAddrArgValue, localAddrArgKey, localAddrArgDefaultValue, "address of embedded mock DeviceHive server")
+	flag.StringVar(&scenarioArgValue, scenarioArgKey, "", "file with commands in Yaml to be sent by embedded mock DeviceHive server")
+	flag.BoolVar(&printConfigArgValue, printConfigArgKey, false, "print effective configuration (secrets are redacted) and exit")
+	flag.BoolVar(&dumpHistoryArgValue, dumpHistoryArgKey, false, "print command and notification history (JSON lines) and exit")
 }
 
 func parseArgs() {
@@ -20,3 +39,27 @@ func parseArgs() {
 		flag.Parse()
 	}
 }
+
+// FileFromArgs returns path of the configuration file, empty if not provided
+func FileFromArgs() string {
+	parseArgs()
+	return confArgValue
+}
+
+// LocalFromArgs returns embedded mock server settings
+func LocalFromArgs() (enabled bool, addr, scenario string) {
+	parseArgs()
+	return localArgValue, localAddrArgValue, scenarioArgValue
+}
+
+// PrintFromArgs returns true if effective configuration should be printed
+func PrintFromArgs() bool {
+	parseArgs()
+	return printConfigArgValue
+}
+
+// DumpHistoryFromArgs returns true if history journal should be printed
+func DumpHistoryFromArgs() bool {
+	parseArgs()
+	return dumpHistoryArgValue
+}
@@ -1,29 +1,213 @@
 package conf
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// MQTT backend configuration
+// topics may contain {device} placeholder (device identifier),
+// notification topic may also contain {name} placeholder (notification name)
+type MQTTConf struct {
+	Broker   string `yaml:"Broker,omitempty"` // ex: tcp://localhost:1883
+	ClientID string `yaml:"ClientID,omitempty"`
+	Username string `yaml:"Username,omitempty"`
+	Password string `yaml:"Password,omitempty" secret:"true"`
+	QoS      byte   `yaml:"QoS,omitempty" env:"QOS"`
+
+	PasswordFile string `yaml:"PasswordFile,omitempty"` // Password is read from the file
+
+	DeviceTopic        string `yaml:"DeviceTopic,omitempty"`
+	NotificationTopic  string `yaml:"NotificationTopic,omitempty"`
+	CommandTopic       string `yaml:"CommandTopic,omitempty"`
+	CommandUpdateTopic string `yaml:"CommandUpdateTopic,omitempty"`
+}
+
+// Policy applied to notifications with the same name (per device)
+type NotificationPolicy struct {
+	MaxRate        float64       `yaml:"MaxRate,omitempty"`        // notifications per second, unlimited if zero
+	Burst          uint64        `yaml:"Burst,omitempty"`          // 1 by default
+	DropDuplicates time.Duration `yaml:"DropDuplicates,omitempty"` // window to drop equal parameters in
+	KeepLatestOnly bool          `yaml:"KeepLatestOnly,omitempty"` // queued notification is replaced by newer one
+	TTL            time.Duration `yaml:"TTL,omitempty"`            // overrides NotificationTTL
+}
+
+// TLS settings of the cloud connection
+type TLSConf struct {
+	CAFile             string `yaml:"CAFile,omitempty"`     // PEM bundle, system roots if empty
+	CertFile           string `yaml:"CertFile,omitempty"`   // client certificate (PEM)
+	KeyFile            string `yaml:"KeyFile,omitempty"`    // client key (PEM)
+	MinVersion         string `yaml:"MinVersion,omitempty"` // "1.0", "1.1", "1.2" or "1.3"
+	PinSHA256          string `yaml:"PinSHA256,omitempty"`  // base64 SHA-256 of server SubjectPublicKeyInfo
+	InsecureSkipVerify bool   `yaml:"InsecureSkipVerify,omitempty"`
+}
+
+// Proxy of the cloud connection
+type ProxyConf struct {
+	URL      string `yaml:"URL,omitempty"` // http://, https:// or socks5://host:port
+	Username string `yaml:"Username,omitempty"`
+	Password string `yaml:"Password,omitempty" secret:"true"`
+	NoProxy  string `yaml:"NoProxy,omitempty"` // comma-separated hosts, NO_PROXY by default
+
+	PasswordFile string `yaml:"PasswordFile,omitempty"` // Password is read from the file
+}
+
+// Device class of the gateway
+type DeviceClassConf struct {
+	Name           string      `yaml:"Name,omitempty"`           // "go-gateway-class" by default
+	Version        string      `yaml:"Version,omitempty"`        // "0.1" by default
+	OfflineTimeout uint64      `yaml:"OfflineTimeout,omitempty"` // in seconds
+	Data           interface{} `yaml:"Data,omitempty"`
+}
+
+// Equipment of the gateway
+type EquipmentConf struct {
+	Code string      `yaml:"Code"`
+	Name string      `yaml:"Name,omitempty"`
+	Type string      `yaml:"Type,omitempty"`
+	Data interface{} `yaml:"Data,omitempty"`
+}
+
 type Conf struct {
+	// Cloud backend: "devicehive" (default) or "mqtt"
+	Backend string   `yaml:"Backend,omitempty"`
+	MQTT    MQTTConf `yaml:"MQTT,omitempty"`
+
+	// TLS settings of all HTTP, websocket and MQTT connections
+	TLS TLSConf `yaml:"TLS,omitempty"`
+
+	// Proxy of all HTTP and websocket connections,
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY are used if not specified
+	Proxy ProxyConf `yaml:"Proxy,omitempty"`
+
 	URL       string `yaml:"URL,omitempty"`
-	AccessKey string `yaml:"AccessKey,omitempty"`
+	AccessKey string `yaml:"AccessKey,omitempty" secret:"true"`
 
 	DeviceID   string `yaml:"DeviceID,omitempty"`
 	DeviceName string `yaml:"DeviceName,omitempty"`
-	DeviceKey  string `yaml:"DeviceKey,omitempty"`
+	DeviceKey  string `yaml:"DeviceKey,omitempty" secret:"true"`
 
 	NetworkName string `yaml:"NetworkName,omitempty"`
-	NetworkKey  string `yaml:"NetworkKey,omitempty"`
+	NetworkKey  string `yaml:"NetworkKey,omitempty" secret:"true"`
 	NetworkDesc string `yaml:"NetworkDescription,omitempty"`
 
+	// Gateway device description
+	DeviceClass DeviceClassConf `yaml:"DeviceClass,omitempty"`
+	Equipment   []EquipmentConf `yaml:"Equipment,omitempty"`
+	DeviceData  interface{}     `yaml:"DeviceData,omitempty"`
+
+	// Secrets are read from the files (if specified)
+	AccessKeyFile  string `yaml:"AccessKeyFile,omitempty"`
+	DeviceKeyFile  string `yaml:"DeviceKeyFile,omitempty"`
+	NetworkKeyFile string `yaml:"NetworkKeyFile,omitempty"`
+
 	// Optional
 	SendNotificatonQueueCapacity uint64 `yaml:"SendNotificatonQueueCapacity,omitempty"`
 	LoggingLevel                 string `yaml:"LoggingLevel,omitempty"`
+
+	// Logging: "logfmt" (default) or "json" records,
+	// levels by component override LoggingLevel
+	LoggingFormat string            `yaml:"LoggingFormat,omitempty"`
+	LoggingLevels map[string]string `yaml:"LoggingLevels,omitempty"`
+
+	// Outbound queue: priority of waiting notifications grows by one every
+	// QueueAging, notifications waiting longer than NotificationTTL are dropped,
+	// the full queue drops the notification to be sent last ("lowest", default)
+	// or the oldest one ("oldest")
+	QueueAging      time.Duration `yaml:"QueueAging,omitempty"`
+	QueueEviction   string        `yaml:"QueueEviction,omitempty"`
+	NotificationTTL time.Duration `yaml:"NotificationTTL,omitempty"`
+
+	// Queued notifications are sent on SIGTERM/SIGINT during ShutdownTimeout,
+	// the rest is spooled (or dropped)
+	ShutdownTimeout time.Duration `yaml:"ShutdownTimeout,omitempty"`
+
+	// Configuration changed by gateway/config/set is rolled back
+	// if the server is not reachable during ConfigGracePeriod
+	ConfigGracePeriod time.Duration `yaml:"ConfigGracePeriod,omitempty"`
+
+	// Store-and-forward buffer, disabled if SpoolDir is empty
+	SpoolDir     string        `yaml:"SpoolDir,omitempty"`
+	SpoolMaxSize uint64        `yaml:"SpoolMaxSize,omitempty"` // in bytes
+	SpoolMaxAge  time.Duration `yaml:"SpoolMaxAge,omitempty"`
+
+	// Files received by gateway/file/* commands, disabled if FileDir is empty
+	FileDir     string `yaml:"FileDir,omitempty"`
+	FileMaxSize uint64 `yaml:"FileMaxSize,omitempty"` // in bytes
+
+	// Journal of commands and notifications, disabled if HistoryDir is empty,
+	// the journal file is rotated when it exceeds HistoryMaxSize
+	HistoryDir     string `yaml:"HistoryDir,omitempty"`
+	HistoryMaxSize uint64 `yaml:"HistoryMaxSize,omitempty"` // in bytes
+	HistoryFiles   int    `yaml:"HistoryFiles,omitempty"`   // including the current one
+
+	// Commands dispatched to registered D-Bus handlers
+	CommandHandlerTimeout time.Duration `yaml:"CommandHandlerTimeout,omitempty"`
+	FailUnhandledCommands *bool         `yaml:"FailUnhandledCommands,omitempty"` // false by default
+
+	// Aggregation windows by notification name, numeric fields
+	// of notifications received during the window are collapsed
+	// into {min, max, avg, count} and sent as a single notification
+	Aggregation map[string]time.Duration `yaml:"Aggregation,omitempty"`
+
+	// Rate limiting and deduplication by notification name
+	Policies map[string]NotificationPolicy `yaml:"Policies,omitempty"`
+
+	// JSON Schema files by notification name and by command name (for results)
+	// non-conforming payloads are rejected ("strict", default) or logged ("warn")
+	NotificationSchemas  map[string]string `yaml:"NotificationSchemas,omitempty"`
+	CommandResultSchemas map[string]string `yaml:"CommandResultSchemas,omitempty"`
+	SchemaValidation     string            `yaml:"SchemaValidation,omitempty"`
+
+	// Prometheus metrics endpoint (ex: 127.0.0.1:9100), disabled if empty,
+	// notifications and commands not listed in MetricsNames are labeled "other"
+	MetricsAddr  string   `yaml:"MetricsAddr,omitempty"`
+	MetricsNames []string `yaml:"MetricsNames,omitempty"`
+
+	// Commands not answered in time are completed with "timeout" status
+	CommandTimeout time.Duration `yaml:"CommandTimeout,omitempty"`
 }
 
 func (c *Conf) fix() {
+	if len(c.Backend) == 0 {
+		c.Backend = "devicehive"
+	}
+
+	if len(c.MQTT.DeviceTopic) == 0 {
+		c.MQTT.DeviceTopic = "devicehive/{device}/device"
+	}
+
+	if len(c.MQTT.NotificationTopic) == 0 {
+		c.MQTT.NotificationTopic = "devicehive/{device}/notification/{name}"
+	}
+
+	if len(c.MQTT.CommandTopic) == 0 {
+		c.MQTT.CommandTopic = "devicehive/{device}/command"
+	}
+
+	if len(c.MQTT.CommandUpdateTopic) == 0 {
+		c.MQTT.CommandUpdateTopic = "devicehive/{device}/command/update"
+	}
+
+	if len(c.DeviceClass.Name) == 0 {
+		c.DeviceClass.Name = "go-gateway-class"
+	}
+
+	if len(c.DeviceClass.Version) == 0 {
+		c.DeviceClass.Version = "0.1"
+	}
+
+	// Yaml maps are not supported by encoding/json
+	c.DeviceClass.Data = fromYAML(c.DeviceClass.Data)
+	for i := range c.Equipment {
+		c.Equipment[i].Data = fromYAML(c.Equipment[i].Data)
+	}
+	c.DeviceData = fromYAML(c.DeviceData)
+
 	if c.SendNotificatonQueueCapacity == 0 {
 		c.SendNotificatonQueueCapacity = 2048
 	}
@@ -31,33 +215,123 @@ func (c *Conf) fix() {
 	if len(c.LoggingLevel) == 0 {
 		c.LoggingLevel = "info"
 	}
+
+	if len(c.LoggingFormat) == 0 {
+		c.LoggingFormat = "logfmt"
+	}
+
+	if len(c.QueueEviction) == 0 {
+		c.QueueEviction = "lowest"
+	}
+
+	if c.SpoolMaxSize == 0 {
+		c.SpoolMaxSize = 16 * 1024 * 1024
+	}
+
+	if c.SpoolMaxAge == 0 {
+		c.SpoolMaxAge = 24 * time.Hour
+	}
+
+	if c.FileMaxSize == 0 {
+		c.FileMaxSize = 16 * 1024 * 1024
+	}
+
+	if c.HistoryMaxSize == 0 {
+		c.HistoryMaxSize = 1024 * 1024
+	}
+
+	if c.HistoryFiles == 0 {
+		c.HistoryFiles = 5
+	}
+
+	if c.CommandHandlerTimeout == 0 {
+		c.CommandHandlerTimeout = 30 * time.Second
+	}
+
+	if c.FailUnhandledCommands == nil {
+		fail := false
+		c.FailUnhandledCommands = &fail
+	}
+
+	if len(c.SchemaValidation) == 0 {
+		c.SchemaValidation = "strict"
+	}
+
+	if c.ShutdownTimeout == 0 {
+		c.ShutdownTimeout = 5 * time.Second
+	}
+
+	if c.ConfigGracePeriod == 0 {
+		c.ConfigGracePeriod = 1 * time.Minute
+	}
+
+	if c.CommandTimeout == 0 {
+		c.CommandTimeout = 5 * time.Minute
+	}
 }
 
+// convert Yaml maps to JSON compatible maps
+func fromYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = fromYAML(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = fromYAML(t[i])
+		}
+	}
+	return v
+}
+
+// FromArgs reads configuration layers:
+// defaults, Yaml file, DEVICEHIVE_* environment variables and secret files
 func FromArgs() (filepath string, c Conf, err error) {
 	parseArgs()
 	filepath = confArgValue
 	if len(filepath) == 0 {
 		c = TestConf()
+		err = c.override(os.Environ())
 		return
 	}
-	c, err = readConf(confArgValue)
+	c, err = readConf(confArgValue, os.Environ())
 	return
 }
 
-func readConf(filepath string) (c Conf, err error) {
+// Read reads configuration file (ex: to reload it)
+func Read(filepath string) (Conf, error) {
+	return readConf(filepath, os.Environ())
+}
+
+func readConf(filepath string, environ []string) (c Conf, err error) {
 	yamlFile, err := ioutil.ReadFile(filepath)
 	if err != nil {
 		return
 	}
-	err = yaml.Unmarshal(yamlFile, &c)
 
-	if err == nil {
-		(&c).fix()
+	(&c).fix() // defaults
+	if err = yaml.Unmarshal(yamlFile, &c); err != nil {
+		return
 	}
-
+	err = (&c).override(environ)
 	return
 }
 
+// apply environment variables and secret files
+func (c *Conf) override(environ []string) error {
+	if err := c.fromEnv(environ); err != nil {
+		return err
+	}
+	if err := c.readSecrets(); err != nil {
+		return err
+	}
+	c.fix()
+	return nil
+}
+
 func TestConf() Conf {
 	c := Conf{}
 
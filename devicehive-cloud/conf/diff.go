@@ -0,0 +1,24 @@
+package conf
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Diff returns names (as in Yaml) of settings changed between configurations
+func Diff(a, b Conf) (changed []string) {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	t := va.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			continue
+		}
+
+		name := t.Field(i).Name
+		if tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; len(tag) != 0 {
+			name = tag
+		}
+		changed = append(changed, name)
+	}
+	return
+}
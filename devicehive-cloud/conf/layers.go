@@ -0,0 +1,157 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// prefix of environment variables, ex: DEVICEHIVE_ACCESS_KEY
+	envPrefix = "DEVICEHIVE_"
+
+	// replacement of secret values
+	redacted = "<redacted>"
+)
+
+// override settings with DEVICEHIVE_* environment variables
+// strings are used as is, other values are parsed as Yaml
+// (ex: DEVICEHIVE_POLICIES='{stats: {MaxRate: 0.1}}')
+func (c *Conf) fromEnv(environ []string) error {
+	env := make(map[string]string)
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 && strings.HasPrefix(kv, envPrefix) {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return fromEnv(reflect.ValueOf(c).Elem(), envPrefix, env)
+}
+
+// set struct fields from environment variables recursively
+func fromEnv(v reflect.Value, prefix string, env map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := prefix + envName(f)
+
+		if f.Type.Kind() == reflect.Struct {
+			if err := fromEnv(v.Field(i), name+"_", env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := env[name]
+		if !ok {
+			continue
+		}
+		if f.Type.Kind() == reflect.String {
+			v.Field(i).SetString(value)
+			continue
+		}
+		if err := yaml.Unmarshal([]byte(value), v.Field(i).Addr().Interface()); err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// get environment variable name of the field, ex: AccessKey -> ACCESS_KEY
+func envName(f reflect.StructField) string {
+	if name := f.Tag.Get("env"); len(name) != 0 {
+		return name
+	}
+
+	name := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if len(name) == 0 {
+		name = f.Name
+	}
+
+	var res []rune
+	r := []rune(name)
+	for i := range r {
+		if i > 0 && unicode.IsUpper(r[i]) &&
+			(unicode.IsLower(r[i-1]) || (i+1 < len(r) && unicode.IsLower(r[i+1]))) {
+			res = append(res, '_')
+		}
+		res = append(res, unicode.ToUpper(r[i]))
+	}
+	return string(res)
+}
+
+// read secrets from *File settings
+func (c *Conf) readSecrets() error {
+	secrets := []struct {
+		file  string
+		value *string
+	}{
+		{c.AccessKeyFile, &c.AccessKey},
+		{c.DeviceKeyFile, &c.DeviceKey},
+		{c.NetworkKeyFile, &c.NetworkKey},
+		{c.MQTT.PasswordFile, &c.MQTT.Password},
+		{c.Proxy.PasswordFile, &c.Proxy.Password},
+	}
+
+	for _, s := range secrets {
+		if len(s.file) == 0 {
+			continue
+		}
+		buf, err := ioutil.ReadFile(s.file)
+		if err != nil {
+			return err
+		}
+		*s.value = strings.TrimSpace(string(buf))
+	}
+	return nil
+}
+
+// Redacted returns a copy with secret values replaced
+func (c Conf) Redacted() Conf {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+// RedactedPatch returns a copy of the settings (see Patch) with secret values replaced
+func RedactedPatch(patch map[string]interface{}) map[string]interface{} {
+	return redactPatch(reflect.TypeOf(Conf{}), patch)
+}
+
+// replace secret settings of the structure type recursively
+func redactPatch(t reflect.Type, patch map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(patch))
+	for k, v := range patch {
+		res[k] = v
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		v, ok := res[yamlName(f)]
+		if !ok || v == nil {
+			continue
+		}
+		nested, isMap := v.(map[string]interface{})
+		switch {
+		case f.Type.Kind() == reflect.Struct && isMap:
+			res[yamlName(f)] = redactPatch(f.Type, nested)
+		case f.Tag.Get("secret") == "true":
+			res[yamlName(f)] = redacted
+		}
+	}
+	return res
+}
+
+// replace non-empty secret fields recursively
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		switch {
+		case t.Field(i).Type.Kind() == reflect.Struct:
+			redact(v.Field(i))
+		case t.Field(i).Tag.Get("secret") == "true" && v.Field(i).Len() != 0:
+			v.Field(i).SetString(redacted)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package conf
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// write file into the directory
+func writeFile(t *testing.T, dir, name, content string) string {
+	file := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+// create temporary directory
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"AccessKey":                    "ACCESS_KEY",
+		"URL":                          "URL",
+		"DeviceID":                     "DEVICE_ID",
+		"ClientID":                     "CLIENT_ID",
+		"CAFile":                       "CA_FILE",
+		"PinSHA256":                    "PIN_SHA256",
+		"MQTT":                         "MQTT",
+		"SendNotificatonQueueCapacity": "SEND_NOTIFICATON_QUEUE_CAPACITY",
+	}
+
+	for name, expected := range tests {
+		f, ok := reflect.TypeOf(Conf{}).FieldByName(name)
+		if !ok {
+			f, ok = reflect.TypeOf(MQTTConf{}).FieldByName(name)
+		}
+		if !ok {
+			f, ok = reflect.TypeOf(TLSConf{}).FieldByName(name)
+		}
+		if !ok {
+			t.Fatalf("unknown field %q", name)
+		}
+		if res := envName(f); res != expected {
+			t.Errorf("%s: %q, expected: %q", name, res, expected)
+		}
+	}
+
+	// Yaml name is used and explicit name wins
+	f, _ := reflect.TypeOf(Conf{}).FieldByName("NetworkDesc")
+	if res := envName(f); res != "NETWORK_DESCRIPTION" {
+		t.Errorf("NetworkDesc: %q", res)
+	}
+	f, _ = reflect.TypeOf(MQTTConf{}).FieldByName("QoS")
+	if res := envName(f); res != "QOS" {
+		t.Errorf("QoS: %q", res)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	var c Conf
+	err := c.fromEnv([]string{
+		"DEVICEHIVE_URL=http://example.com/api",
+		"DEVICEHIVE_ACCESS_KEY=key=with=equals",
+		"DEVICEHIVE_MQTT_QOS=2",
+		"DEVICEHIVE_MQTT_BROKER=tcp://broker:1883",
+		"DEVICEHIVE_TLS_INSECURE_SKIP_VERIFY=true",
+		"DEVICEHIVE_COMMAND_TIMEOUT=30s",
+		"DEVICEHIVE_POLICIES={stats: {MaxRate: 0.5}}",
+		"DEVICEHIVE_DEVICE_NAME=123", // strings are not parsed
+		"OTHER_URL=http://other",
+		"DEVICEHIVE_UNKNOWN=1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case c.URL != "http://example.com/api":
+		t.Errorf("unexpected URL %q", c.URL)
+	case c.AccessKey != "key=with=equals":
+		t.Errorf("unexpected AccessKey %q", c.AccessKey)
+	case c.MQTT.QoS != 2 || c.MQTT.Broker != "tcp://broker:1883":
+		t.Errorf("unexpected MQTT %+v", c.MQTT)
+	case !c.TLS.InsecureSkipVerify:
+		t.Error("TLS.InsecureSkipVerify is not set")
+	case c.CommandTimeout != 30*time.Second:
+		t.Errorf("unexpected CommandTimeout %s", c.CommandTimeout)
+	case c.Policies["stats"].MaxRate != 0.5:
+		t.Errorf("unexpected Policies %+v", c.Policies)
+	case c.DeviceName != "123":
+		t.Errorf("unexpected DeviceName %q", c.DeviceName)
+	}
+
+	if err = c.fromEnv([]string{"DEVICEHIVE_COMMAND_TIMEOUT=soon"}); err == nil {
+		t.Error("invalid duration is accepted")
+	}
+}
+
+func TestLayers(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	keyFile := writeFile(t, dir, "access-key", "file-key\n")
+	passwordFile := writeFile(t, dir, "mqtt-password", " file-password ")
+	confFile := writeFile(t, dir, "conf.yml", `
+URL: http://file.example.com
+AccessKey: yaml-key
+DeviceID: yaml-device
+DeviceName: yaml-name
+CommandTimeout: 1m
+MQTT:
+  Broker: tcp://yaml:1883
+  Password: yaml-password
+  PasswordFile: `+passwordFile+`
+`)
+
+	c, err := readConf(confFile, []string{
+		"DEVICEHIVE_DEVICE_NAME=env-name",
+		"DEVICEHIVE_ACCESS_KEY=env-key",
+		"DEVICEHIVE_ACCESS_KEY_FILE=" + keyFile,
+		"DEVICEHIVE_MQTT_BROKER=tcp://env:1883",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"default", c.Backend, "devicehive"},
+		{"default", c.ShutdownTimeout, 5 * time.Second},
+		{"file", c.URL, "http://file.example.com"},
+		{"file", c.DeviceID, "yaml-device"},
+		{"file over default", c.CommandTimeout, time.Minute},
+		{"env over file", c.DeviceName, "env-name"},
+		{"env over file", c.MQTT.Broker, "tcp://env:1883"},
+		{"secret file over env", c.AccessKey, "file-key"},
+		{"secret file over file", c.MQTT.Password, "file-password"},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.value, test.expected) {
+			t.Errorf("%s: %v, expected: %v", test.name, test.value, test.expected)
+		}
+	}
+}
+
+func TestLayerErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	confFile := writeFile(t, dir, "conf.yml", "AccessKeyFile: "+filepath.Join(dir, "missing"))
+	if _, err := readConf(confFile, nil); err == nil {
+		t.Error("missing secret file is ignored")
+	}
+
+	confFile = writeFile(t, dir, "conf.yml", "URL: http://example.com")
+	if _, err := readConf(confFile, []string{"DEVICEHIVE_MQTT_QOS=high"}); err == nil {
+		t.Error("invalid environment variable is ignored")
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	c := Conf{AccessKey: "secret", DeviceKey: "", URL: "http://example.com"}
+	c.MQTT.Password = "secret"
+	c.Proxy.Password = "secret"
+
+	r := c.Redacted()
+	switch {
+	case r.AccessKey != redacted || r.MQTT.Password != redacted || r.Proxy.Password != redacted:
+		t.Errorf("secrets are not redacted: %+v", r)
+	case r.DeviceKey != "":
+		t.Errorf("empty secret is redacted: %q", r.DeviceKey)
+	case r.URL != c.URL:
+		t.Errorf("URL is changed: %q", r.URL)
+	case c.AccessKey != "secret":
+		t.Error("original configuration is changed")
+	}
+}
+
+func TestRedactedPatch(t *testing.T) {
+	patch := map[string]interface{}{
+		"AccessKey":    "secret",
+		"DeviceKey":    nil,
+		"LoggingLevel": "debug",
+		"MQTT":         map[string]interface{}{"Password": "secret", "Username": "user"},
+	}
+
+	r := RedactedPatch(patch)
+	mqtt, _ := r["MQTT"].(map[string]interface{})
+	switch {
+	case r["AccessKey"] != redacted || mqtt["Password"] != redacted:
+		t.Errorf("secrets are not redacted: %v", r)
+	case r["DeviceKey"] != nil, r["LoggingLevel"] != "debug", mqtt["Username"] != "user":
+		t.Errorf("other settings are changed: %v", r)
+	case patch["AccessKey"] != "secret", patch["MQTT"].(map[string]interface{})["Password"] != "secret":
+		t.Error("original settings are changed")
+	}
+}
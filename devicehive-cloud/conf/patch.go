@@ -0,0 +1,317 @@
+package conf
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// settings which may be changed remotely (see Patch), nested settings
+// are listed with their parent; paths of local files and directories,
+// the device identifier, the metrics endpoint and disabling of TLS
+// verification are never changed remotely
+var remoteSettings = map[string]bool{
+	"Backend":                      true,
+	"URL":                          true,
+	"AccessKey":                    true,
+	"DeviceName":                   true,
+	"DeviceKey":                    true,
+	"NetworkName":                  true,
+	"NetworkKey":                   true,
+	"NetworkDescription":           true,
+	"DeviceClass":                  true,
+	"Equipment":                    true,
+	"DeviceData":                   true,
+	"SendNotificatonQueueCapacity": true,
+	"LoggingLevel":                 true,
+	"LoggingFormat":                true,
+	"LoggingLevels":                true,
+	"QueueAging":                   true,
+	"QueueEviction":                true,
+	"NotificationTTL":              true,
+	"ShutdownTimeout":              true,
+	"ConfigGracePeriod":            true,
+	"SpoolMaxSize":                 true,
+	"SpoolMaxAge":                  true,
+	"FileMaxSize":                  true,
+	"HistoryMaxSize":               true,
+	"HistoryFiles":                 true,
+	"CommandHandlerTimeout":        true,
+	"FailUnhandledCommands":        true,
+	"Aggregation":                  true,
+	"Policies":                     true,
+	"SchemaValidation":             true,
+	"MetricsNames":                 true,
+	"CommandTimeout":               true,
+
+	"MQTT.Broker":             true,
+	"MQTT.ClientID":           true,
+	"MQTT.Username":           true,
+	"MQTT.Password":           true,
+	"MQTT.QoS":                true,
+	"MQTT.DeviceTopic":        true,
+	"MQTT.NotificationTopic":  true,
+	"MQTT.CommandTopic":       true,
+	"MQTT.CommandUpdateTopic": true,
+
+	"TLS.MinVersion": true,
+	"TLS.PinSHA256":  true,
+
+	"Proxy.URL":      true,
+	"Proxy.Username": true,
+	"Proxy.Password": true,
+	"Proxy.NoProxy":  true,
+}
+
+// Patch sets settings of the Yaml configuration (null removes the setting),
+// returns updated content; nested objects (ex: MQTT, TLS) are merged with
+// the current ones, arrays are replaced; settings not allowed remotely
+// (see remoteSettings), unknown settings, values of wrong type and durations
+// given as numbers (not "30s") are rejected, comments are not kept
+func Patch(content []byte, patch map[string]interface{}) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if err := checkRemote(patch, ""); err != nil {
+		return nil, err
+	}
+	if err := checkDurations(reflect.TypeOf(Conf{}), patch, ""); err != nil {
+		return nil, err
+	}
+
+	res, err := yaml.Marshal(merge(doc, patch))
+	if err != nil {
+		return nil, err
+	}
+
+	var c Conf
+	if err = yaml.UnmarshalStrict(res, &c); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// set values of the patch, objects are merged recursively
+func merge(doc yaml.MapSlice, patch map[string]interface{}) yaml.MapSlice {
+	for _, key := range sortedKeys(patch) {
+		value := patch[key]
+		i := 0
+		for i < len(doc) && fmt.Sprint(doc[i].Key) != key {
+			i++
+		}
+		switch {
+		case value == nil && i < len(doc):
+			doc = append(doc[:i], doc[i+1:]...)
+		case value == nil:
+		case i < len(doc):
+			current, isMap := doc[i].Value.(yaml.MapSlice)
+			if nested, ok := value.(map[string]interface{}); ok && isMap {
+				doc[i].Value = merge(current, nested)
+			} else {
+				doc[i].Value = toYAML(value)
+			}
+		default:
+			doc = append(doc, yaml.MapItem{Key: key, Value: toYAML(value)})
+		}
+	}
+	return doc
+}
+
+// check that all settings of the patch may be changed remotely,
+// objects of nested settings are checked setting by setting
+func checkRemote(patch map[string]interface{}, path string) error {
+	for _, key := range sortedKeys(patch) {
+		name := join(path, key)
+		if remoteSettings[name] {
+			continue
+		}
+		if nested, ok := patch[key].(map[string]interface{}); ok && hasNested(name) {
+			if err := checkRemote(nested, name); err != nil {
+				return err
+			}
+			continue
+		}
+		return fmt.Errorf("%s cannot be changed remotely", name)
+	}
+	return nil
+}
+
+// check if nested settings of the object may be changed remotely
+func hasNested(name string) bool {
+	for s := range remoteSettings {
+		if strings.HasPrefix(s, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// numbers are nanoseconds for Yaml, which is never meant
+func checkDurations(t reflect.Type, value interface{}, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == durationType {
+		if _, ok := value.(float64); ok {
+			return fmt.Errorf("%s: duration is expected as string (ex: \"30s\")", path)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, _ := value.(map[string]interface{})
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := yamlName(f)
+			if v, ok := m[name]; ok {
+				if err := checkDurations(f.Type, v, join(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Map:
+		m, _ := value.(map[string]interface{})
+		for k, v := range m {
+			if err := checkDurations(t.Elem(), v, join(path, k)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		a, _ := value.([]interface{})
+		for i, v := range a {
+			if err := checkDurations(t.Elem(), v, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// get Yaml key of the field
+func yamlName(f reflect.StructField) string {
+	if name := strings.Split(f.Tag.Get("yaml"), ",")[0]; len(name) != 0 {
+		return name
+	}
+	return strings.ToLower(f.Name) // default of yaml.v2
+}
+
+// path of the nested setting
+func join(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// convert JSON values to Yaml, integral numbers are not floats
+func toYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < 1<<53 {
+			return int64(t)
+		}
+	case map[string]interface{}:
+		m := make(yaml.MapSlice, 0, len(t))
+		for _, k := range sortedKeys(t) {
+			m = append(m, yaml.MapItem{Key: k, Value: toYAML(t[k])})
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = toYAML(t[i])
+		}
+	}
+	return v
+}
+
+// get map keys in order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteFile replaces the file atomically: content is written
+// to a temporary file in the same directory which is renamed then
+func WriteFile(path string, content []byte) error {
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // if not renamed
+
+	if _, err = tmp.Write(content); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// files kept while remote configuration change is not confirmed
+const (
+	prevSuffix    = ".prev"
+	pendingSuffix = ".pending"
+)
+
+// BeginChange keeps the previous content as <file>.prev and marks the change
+// as pending, the change not confirmed before restart is rolled back by Rollback
+func BeginChange(path string, previous []byte) error {
+	if err := WriteFile(path+prevSuffix, previous); err != nil {
+		return err
+	}
+	return WriteFile(path+pendingSuffix, nil)
+}
+
+// ConfirmChange removes the pending mark, <file>.prev is kept
+func ConfirmChange(path string) error {
+	if err := os.Remove(path + pendingSuffix); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Rollback restores <file>.prev if the change is pending, returns true if restored
+func Rollback(path string) (bool, error) {
+	if _, err := os.Stat(path + pendingSuffix); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	previous, err := ioutil.ReadFile(path + prevSuffix)
+	if err != nil {
+		return false, err
+	}
+	if err = WriteFile(path, previous); err != nil {
+		return false, err
+	}
+	return true, ConfirmChange(path)
+}
@@ -0,0 +1,190 @@
+package conf
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const patchBase = `# comment
+URL: http://localhost/api
+LoggingLevel: info
+MQTT:
+  Broker: tcp://localhost:1883
+  Username: user
+Equipment:
+- Code: a
+`
+
+// apply JSON patch to the base configuration
+func patchOf(t *testing.T, patch string) (Conf, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal([]byte(patch), &p); err != nil {
+		t.Fatal(err)
+	}
+	content, err := Patch([]byte(patchBase), p)
+	if err != nil {
+		return Conf{}, err
+	}
+	var c Conf
+	if err = yaml.UnmarshalStrict(content, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c, nil
+}
+
+func TestPatch(t *testing.T) {
+	c, err := patchOf(t, `{
+		"LoggingLevel": "debug",
+		"URL": null,
+		"NotificationTTL": "10m",
+		"MQTT": {"QoS": 1, "Username": null},
+		"Aggregation": {"temp": "30s"},
+		"Equipment": [{"Code": "b"}],
+		"HistoryFiles": 3
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.LoggingLevel != "debug" || len(c.URL) != 0 || c.NotificationTTL != 10*time.Minute || c.HistoryFiles != 3 {
+		t.Errorf("top-level settings are not patched: %+v", c)
+	}
+	// nested objects are merged, arrays are replaced
+	if c.MQTT.Broker != "tcp://localhost:1883" || c.MQTT.QoS != 1 || len(c.MQTT.Username) != 0 {
+		t.Errorf("MQTT settings are not merged: %+v", c.MQTT)
+	}
+	if c.Aggregation["temp"] != 30*time.Second {
+		t.Errorf("unexpected aggregation %v", c.Aggregation)
+	}
+	if len(c.Equipment) != 1 || c.Equipment[0].Code != "b" {
+		t.Errorf("equipment is not replaced: %+v", c.Equipment)
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	tests := []string{
+		`{"Unknown": 1}`,
+		`{"MQTT": {"Unknown": 1}}`,
+		`{"HistoryFiles": "three"}`,
+		`{"CommandTimeout": 30}`,
+		`{"Aggregation": {"temp": 30}}`,
+		`{"Policies": {"temp": {"TTL": 60}}}`,
+
+		// local paths and secret files
+		`{"FileDir": "/"}`,
+		`{"SpoolDir": "/tmp"}`,
+		`{"NotificationSchemas": {"temp": "/etc/shadow"}}`,
+		`{"AccessKeyFile": "/etc/shadow"}`,
+		`{"MQTT": {"PasswordFile": "/etc/shadow"}}`,
+		`{"Proxy": {"URL": "http://proxy:8080", "PasswordFile": "/etc/shadow"}}`,
+		`{"TLS": {"CAFile": "/tmp/ca.pem"}}`,
+		`{"TLS": {"InsecureSkipVerify": true}}`,
+		`{"TLS": null}`,
+		`{"DeviceID": "other"}`,
+		`{"MetricsAddr": "0.0.0.0:9100"}`,
+	}
+	for _, patch := range tests {
+		if _, err := patchOf(t, patch); err == nil {
+			t.Errorf("%s is accepted", patch)
+		}
+	}
+}
+
+func TestRemoteSettings(t *testing.T) {
+	// listed settings exist
+	for name := range remoteSettings {
+		typ := reflect.TypeOf(Conf{})
+		for _, key := range strings.Split(name, ".") {
+			found := false
+			for i := 0; !found && i < typ.NumField(); i++ {
+				if f := typ.Field(i); yamlName(f) == key {
+					typ, found = f.Type, true
+				}
+			}
+			if !found {
+				t.Errorf("unknown setting %q", name)
+				break
+			}
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	// new file is private, mode of existing one is kept
+	file := filepath.Join(dir, "conf.yml")
+	if err := WriteFile(file, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode of new file %v (error: %v)", info.Mode(), err)
+	}
+	if err := os.Chmod(file, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(file, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("mode is not kept %v (error: %v)", info.Mode(), err)
+	}
+	if buf, _ := ioutil.ReadFile(file); string(buf) != "b" {
+		t.Errorf("unexpected content %q", buf)
+	}
+
+	// no temporary files are left
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files in the directory", len(files))
+	}
+	if err := WriteFile(filepath.Join(dir, "missing", "conf.yml"), nil); err == nil {
+		t.Error("file is written into missing directory")
+	}
+}
+
+func TestRollback(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	file := writeFile(t, dir, "conf.yml", "previous")
+
+	// nothing is pending
+	if restored, err := Rollback(file); restored || err != nil {
+		t.Fatalf("unexpected rollback (error: %v)", err)
+	}
+
+	// confirmed change is kept
+	if err := BeginChange(file, []byte("previous")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "conf.yml", "confirmed")
+	if err := ConfirmChange(file); err != nil {
+		t.Fatal(err)
+	}
+	if restored, err := Rollback(file); restored || err != nil {
+		t.Errorf("confirmed change is rolled back (error: %v)", err)
+	}
+
+	// pending change is rolled back once
+	if err := BeginChange(file, []byte("confirmed")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, dir, "conf.yml", "pending")
+	if restored, err := Rollback(file); !restored || err != nil {
+		t.Errorf("pending change is not rolled back (error: %v)", err)
+	}
+	if buf, _ := ioutil.ReadFile(file); string(buf) != "confirmed" {
+		t.Errorf("unexpected content %q", buf)
+	}
+	if restored, err := Rollback(file); restored || err != nil {
+		t.Errorf("change is rolled back twice (error: %v)", err)
+	}
+}
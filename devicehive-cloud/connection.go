@@ -0,0 +1,718 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/backend"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/devicehive-go/devicehive"
+	"github.com/devicehive/devicehive-go/devicehive/core"
+)
+
+const (
+	// reconnect backoff limits
+	minReconnectDelay = 1 * time.Second
+	maxReconnectDelay = 5 * time.Minute
+
+	// old subscriptions are cancelled in background
+	unsubscribeTimeout = 5 * time.Second
+
+	// server availability is checked periodically
+	healthCheckInterval = 1 * time.Minute
+)
+
+var (
+	errNotConnected       = errors.New("not connected to DeviceHive server")
+	errSubscriptionClosed = errors.New("command subscription is closed")
+	errUnknownDevice      = errors.New("unknown device")
+	errDeviceExists       = errors.New("device is already registered")
+	errReconfigured       = errors.New("configuration is changed")
+)
+
+// connection states
+const (
+	StateDisconnected = "disconnected"
+	StateConnecting   = "connecting"
+	StateConnected    = "connected"
+)
+
+// creates new cloud backend
+type serviceFactory func(config conf.Conf) (backend.Backend, error)
+
+// connection status snapshot
+type connectionStatus struct {
+	State         string
+	LastError     string
+	LastTimestamp string
+}
+
+// called on connection status change,
+// transition is true if connection state is changed
+type statusHandler func(status connectionStatus, transition bool)
+
+// command received for a device
+type deviceCommand struct {
+	DeviceId string // empty for the gateway device
+	*core.Command
+}
+
+// registered device and its command subscription state
+type deviceEntry struct {
+	device *core.Device
+
+	lastTimestamp string          // last seen server timestamp
+	lastIds       map[uint64]bool // commands received at last timestamp
+
+	removed chan struct{} // closed when the device is removed
+}
+
+// connected session, closed on any error
+type session struct {
+	service backend.Backend
+	stop    chan struct{} // closed when session is over
+	broken  chan error    // the first subscription error
+
+	mu         sync.Mutex
+	subscribed map[string]*core.Device // by device identifier
+	failed     map[string]*deviceEntry // proxied devices to register again
+}
+
+// supervised connection to the DeviceHive server
+// (re)connects with exponential backoff, (re)registers all devices
+// and (re)subscribes to commands from the last seen timestamp
+type connection struct {
+	config     conf.Conf
+	newService serviceFactory
+	onChange   statusHandler // optional
+
+	sync.RWMutex
+	session   *session // nil if not connected
+	state     string
+	lastError string
+
+	gateway *deviceEntry
+	devices map[string]*deviceEntry // proxied devices by identifier
+
+	lastTimestamp string    // last seen server timestamp (of all devices)
+	lastContact   time.Time // last successful server call
+	connectedAt   time.Time // when the current session is established
+	commands      chan deviceCommand
+	reconfigured  chan struct{} // reconnect is requested
+
+	// the last closed session is over, accessed by run() only
+	lastClosed <-chan struct{}
+}
+
+// create new connection, call run() to start it
+func newConnection(config conf.Conf, newService serviceFactory) *connection {
+	c := new(connection)
+	c.config = config
+	c.newService = newService
+	c.state = StateDisconnected
+	c.devices = make(map[string]*deviceEntry)
+	c.commands = make(chan deviceCommand)
+	c.reconfigured = make(chan struct{}, 1)
+	c.gateway = newDeviceEntry(newGatewayDevice(config))
+	return c
+}
+
+// create the gateway device from configuration
+func newGatewayDevice(config conf.Conf) *core.Device {
+	class := devicehive.NewDeviceClass(config.DeviceClass.Name, config.DeviceClass.Version)
+	class.OfflineTimeout = config.DeviceClass.OfflineTimeout
+	class.Data = config.DeviceClass.Data
+	for _, e := range config.Equipment {
+		class.Equipment = append(class.Equipment, &core.Equipment{
+			Code: e.Code,
+			Name: e.Name,
+			Type: e.Type,
+			Data: e.Data,
+		})
+	}
+
+	device := devicehive.NewDevice(config.DeviceID, config.DeviceName, class)
+	device.Key = config.DeviceKey
+	device.Data = config.DeviceData
+	if len(config.NetworkName) != 0 || len(config.NetworkKey) != 0 {
+		device.Network = devicehive.NewNetwork(config.NetworkName, config.NetworkKey)
+		device.Network.Description = config.NetworkDesc
+	}
+	return device
+}
+
+// create new device entry
+func newDeviceEntry(device *core.Device) *deviceEntry {
+	return &deviceEntry{
+		device:  device,
+		lastIds: make(map[uint64]bool),
+		removed: make(chan struct{}),
+	}
+}
+
+// get device by identifier, empty identifier means the gateway device
+// should be called under lock
+func (c *connection) entry(deviceId string) *deviceEntry {
+	if len(deviceId) == 0 {
+		return c.gateway
+	}
+	return c.devices[deviceId]
+}
+
+// get connected service and the registered device
+func (c *connection) Service(deviceId string) (backend.Backend, *core.Device, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	e := c.entry(deviceId)
+	switch {
+	case e == nil:
+		return nil, nil, errUnknownDevice
+	case c.session == nil:
+		return nil, nil, errNotConnected
+	}
+	return c.session.service, e.device, nil
+}
+
+// get the running configuration
+func (c *connection) Config() conf.Conf {
+	c.RLock()
+	defer c.RUnlock()
+	return c.config
+}
+
+// apply new configuration, if reconnect is true the gateway device
+// is created again and the current session is broken to register
+// all devices with new settings, equipment and data updated at runtime
+// (see UpdateDevice) are kept unless they are changed in configuration
+func (c *connection) Reconfigure(config conf.Conf, reconnect bool) {
+	c.Lock()
+	old := c.config
+	c.config = config
+	if reconnect {
+		gateway := newDeviceEntry(newGatewayDevice(config))
+		if gateway.device.Id == c.gateway.device.Id {
+			// the same device, keep receiving commands from the last timestamp
+			gateway.lastTimestamp = c.gateway.lastTimestamp
+			gateway.lastIds = c.gateway.lastIds
+
+			running := c.gateway.device
+			if reflect.DeepEqual(old.Equipment, config.Equipment) && running.DeviceClass != nil {
+				gateway.device.DeviceClass.Equipment = running.DeviceClass.Equipment
+			}
+			if reflect.DeepEqual(old.DeviceData, config.DeviceData) {
+				gateway.device.Data = running.Data
+			}
+		}
+		c.gateway = gateway
+		for _, e := range c.devices {
+			device := *e.device // the device may be in use
+			device.Network = gateway.device.Network
+			e.device = &device
+		}
+	}
+	c.Unlock()
+
+	if reconnect {
+		select {
+		case c.reconfigured <- struct{}{}:
+		default: // already requested
+		}
+	}
+}
+
+// get connection status
+func (c *connection) Status() connectionStatus {
+	c.RLock()
+	defer c.RUnlock()
+	return c.status()
+}
+
+// get connection status, should be called under lock
+func (c *connection) status() connectionStatus {
+	return connectionStatus{
+		State:         c.state,
+		LastError:     c.lastError,
+		LastTimestamp: c.lastTimestamp,
+	}
+}
+
+// remember successful server call
+func (c *connection) touch() {
+	c.Lock()
+	defer c.Unlock()
+	c.lastContact = time.Now()
+}
+
+// get time of the last successful server call
+func (c *connection) LastContact() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.lastContact
+}
+
+// get time the current session is established (zero if not connected)
+func (c *connection) ConnectedAt() time.Time {
+	c.RLock()
+	defer c.RUnlock()
+	return c.connectedAt
+}
+
+// received commands of all devices
+func (c *connection) Commands() <-chan deviceCommand {
+	return c.commands
+}
+
+// add proxied device to the gateway network, it is registered immediately
+// if connected or on the next (re)connect otherwise, the device is not added on error
+func (c *connection) AddDevice(device *core.Device) error {
+	c.Lock()
+	if _, ok := c.devices[device.Id]; ok {
+		c.Unlock()
+		return errDeviceExists
+	}
+	d := *device // the caller's device is not modified
+	d.Network = c.gateway.device.Network
+	device = &d
+	e := newDeviceEntry(device)
+	c.devices[device.Id] = e
+	s := c.session
+	c.Unlock()
+
+	if s == nil {
+		return nil // will be registered on connect
+	}
+
+	info, err := s.service.GetServerInfo(waitTimeout)
+	if err == nil {
+		c.Lock()
+		e.lastTimestamp = info.Timestamp
+		c.Unlock()
+		err = c.register(s, device.Id, e)
+	}
+	if err == errNotConnected {
+		return nil // the session is over, will be registered on connect
+	}
+	if err != nil {
+		c.removeEntry(device.Id, e)
+	}
+	return err
+}
+
+// update device description, the device is registered again if connected
+func (c *connection) UpdateDevice(deviceId string, update func(device *core.Device)) error {
+	c.Lock()
+	e := c.entry(deviceId)
+	if e == nil {
+		c.Unlock()
+		return errUnknownDevice
+	}
+
+	// modify a copy, the device may be in use
+	device := *e.device
+	if device.DeviceClass != nil {
+		class := *device.DeviceClass
+		device.DeviceClass = &class
+	}
+	update(&device)
+	e.device = &device
+	s := c.session
+	c.Unlock()
+
+	if s == nil {
+		return nil // will be registered on connect
+	}
+	return s.service.RegisterDevice(&device, waitTimeout)
+}
+
+// remove proxied device, its commands are unsubscribed
+func (c *connection) RemoveDevice(deviceId string) {
+	c.RLock()
+	e := c.devices[deviceId]
+	c.RUnlock()
+	if e != nil {
+		c.removeEntry(deviceId, e)
+	}
+}
+
+// remove the device entry (if it is not replaced yet)
+// and stop its command subscription
+func (c *connection) removeEntry(deviceId string, e *deviceEntry) {
+	c.Lock()
+	if c.devices[deviceId] != e {
+		c.Unlock()
+		return
+	}
+	delete(c.devices, deviceId)
+	close(e.removed)
+	s := c.session
+	c.Unlock()
+
+	if s != nil {
+		s.unsubscribe(deviceId)
+	}
+}
+
+// cancel command subscription of the device in background
+func (s *session) unsubscribe(deviceId string) {
+	s.mu.Lock()
+	device, ok := s.subscribed[deviceId]
+	if ok {
+		delete(s.subscribed, deviceId)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	go func() {
+		if err := s.service.UnsubscribeCommands(device, unsubscribeTimeout); err != nil {
+			connLog.Debugf("Cannot unsubscribe commands of device %q (error: %s)", device.Id, err)
+		}
+	}()
+}
+
+// stop session, release backend resources in background,
+// returns channel closed when the backend is closed
+func (s *session) close() <-chan struct{} {
+	close(s.stop)
+
+	s.mu.Lock()
+	devices := make([]*core.Device, 0, len(s.subscribed))
+	for _, device := range s.subscribed {
+		devices = append(devices, device)
+	}
+	s.subscribed = nil
+	s.mu.Unlock()
+
+	// the server may be unreachable, so unsubscribes are limited
+	// by unsubscribeTimeout all together
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for _, device := range devices {
+			wg.Add(1)
+			go func(device *core.Device) {
+				defer wg.Done()
+				if err := s.service.UnsubscribeCommands(device, unsubscribeTimeout); err != nil {
+					connLog.Debugf("Cannot unsubscribe commands of device %q (error: %s)", device.Id, err)
+				}
+			}(device)
+		}
+		wg.Wait()
+		if closer, ok := s.service.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+	return done
+}
+
+// keep connection alive, never returns
+func (c *connection) run() {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	delay := minReconnectDelay
+
+	for attempt := 0; ; attempt++ {
+		if attempt != 0 {
+			reconnects.Inc()
+		}
+		c.setState(StateConnecting, nil, nil)
+		s, err := c.connect()
+		if err != nil {
+			c.setState(StateDisconnected, nil, err)
+
+			// exponential backoff with jitter
+			sleep := delay/2 + time.Duration(rnd.Int63n(int64(delay)))
+			connLog.Infof("reconnecting in %s", sleep)
+			select {
+			case <-time.After(sleep):
+			case <-c.reconfigured: // try new configuration immediately
+			}
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			continue
+		}
+
+		delay = minReconnectDelay
+		c.setState(StateConnected, s, nil)
+		err = c.listen(s)
+		c.setState(StateDisconnected, nil, err)
+		c.lastClosed = s.close()
+	}
+}
+
+// get server info, register devices and subscribe commands
+func (c *connection) connect() (*session, error) {
+	select {
+	case <-c.reconfigured: // the latest configuration is used anyway
+	default:
+	}
+
+	// the previous backend is closed before the new one is created,
+	// they may share default transports (see transport.UseByDefault)
+	if c.lastClosed != nil {
+		<-c.lastClosed
+	}
+
+	config := c.Config()
+	service, err := c.newService(config)
+	if err != nil {
+		connLog.Warnf("Cannot create %s backend (error: %s)", config.Backend, err)
+		return nil, err
+	}
+
+	s := &session{
+		service:    service,
+		stop:       make(chan struct{}),
+		broken:     make(chan error, 1),
+		subscribed: make(map[string]*core.Device),
+		failed:     make(map[string]*deviceEntry),
+	}
+
+	// getting server info
+	info, err := service.GetServerInfo(waitTimeout)
+	if err != nil {
+		connLog.Warnf("Cannot get service info (error: %s)", err)
+		c.lastClosed = s.close()
+		return nil, err
+	}
+	c.touch()
+
+	c.Lock()
+	entries := map[string]*deviceEntry{"": c.gateway}
+	for id, e := range c.devices {
+		entries[id] = e
+	}
+	for _, e := range entries {
+		if len(e.lastTimestamp) == 0 {
+			e.lastTimestamp = info.Timestamp
+		}
+	}
+	c.Unlock()
+
+	for id, e := range entries {
+		err = c.register(s, id, e)
+		switch {
+		case err != nil && len(id) == 0:
+			c.lastClosed = s.close()
+			return nil, err
+		case err != nil:
+			// proxied devices should not break the gateway connection
+			// they are registered again on the next health check
+			connLog.Warnf("Proxied device %q is not registered (error: %s)", id, err)
+			s.mu.Lock()
+			s.failed[id] = e
+			s.mu.Unlock()
+		}
+	}
+
+	return s, nil
+}
+
+// register proxied devices failed to register on connect
+func (c *connection) retryFailed(s *session) {
+	s.mu.Lock()
+	failed := s.failed
+	s.failed = make(map[string]*deviceEntry)
+	s.mu.Unlock()
+
+	for id, e := range failed {
+		c.RLock()
+		current := c.devices[id] == e
+		c.RUnlock()
+		if !current {
+			continue // removed or replaced
+		}
+		if err := c.register(s, id, e); err != nil {
+			s.mu.Lock()
+			s.failed[id] = e
+			s.mu.Unlock()
+			continue
+		}
+		connLog.Infof("Proxied device %q is registered", id)
+	}
+}
+
+// register device and subscribe its commands from the last seen timestamp
+func (c *connection) register(s *session, deviceId string, e *deviceEntry) error {
+	select {
+	case <-s.stop:
+		return errNotConnected // nothing is dialed by the closed session
+	default:
+	}
+
+	c.RLock()
+	device := e.device
+	timestamp := e.lastTimestamp
+	c.RUnlock()
+
+	err := s.service.RegisterDevice(device, waitTimeout)
+	if err != nil {
+		connLog.Warnf("Cannot register device %q (error: %s)", device.Id, err)
+		return err
+	}
+
+	listener, err := s.service.SubscribeCommands(device, timestamp, waitTimeout)
+	if err != nil {
+		connLog.Warnf("Cannot subscribe commands of device %q (error: %s)", device.Id, err)
+		return err
+	}
+
+	s.mu.Lock()
+	if s.subscribed != nil {
+		s.subscribed[deviceId] = device
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-e.removed:
+		// removed while subscribing
+		s.unsubscribe(deviceId)
+		return nil
+	default:
+	}
+
+	go c.forward(s, deviceId, e, listener)
+	return nil
+}
+
+// forward device commands until session is over or the device is removed
+func (c *connection) forward(s *session, deviceId string, e *deviceEntry, listener *core.CommandListener) {
+	for {
+		select {
+		case cmd, ok := <-listener.C:
+			if !ok {
+				select {
+				case <-e.removed:
+					return // unsubscribed
+				default:
+				}
+				select {
+				case s.broken <- errSubscriptionClosed:
+				default: // already broken
+				}
+				return
+			}
+			c.touch()
+			if c.seen(deviceId, e, cmd) {
+				connLog.Debugf("skipping already received command %+v", cmd)
+				continue
+			}
+			select {
+			case c.commands <- deviceCommand{DeviceId: deviceId, Command: cmd}:
+			case <-s.stop:
+				return
+			case <-e.removed:
+				return
+			}
+
+		case <-s.stop:
+			return
+		case <-e.removed:
+			return
+		}
+	}
+}
+
+// wait until session is broken
+func (c *connection) listen(s *session) error {
+	health := time.NewTicker(healthCheckInterval)
+	defer health.Stop()
+
+	for {
+		select {
+		case err := <-s.broken:
+			return err
+
+		case <-c.reconfigured:
+			connLog.Infof("Reconnecting with new configuration")
+			return errReconfigured
+
+		case <-health.C:
+			if _, err := s.service.GetServerInfo(waitTimeout); err != nil {
+				connLog.Warnf("Server is not available (error: %s)", err)
+				return err
+			}
+			c.touch()
+			c.retryFailed(s)
+		}
+	}
+}
+
+// check the command is already received and update the last timestamp
+func (c *connection) seen(deviceId string, e *deviceEntry, cmd *core.Command) bool {
+	c.Lock()
+
+	if c.entry(deviceId) != e {
+		c.Unlock()
+		return true // device is removed
+	}
+
+	// DeviceHive timestamps are fixed width, so can be compared as strings
+	switch {
+	case len(cmd.Timestamp) == 0:
+		c.Unlock()
+		return false
+	case cmd.Timestamp < e.lastTimestamp:
+		c.Unlock()
+		return true
+	case cmd.Timestamp == e.lastTimestamp:
+		if e.lastIds[cmd.Id] {
+			c.Unlock()
+			return true
+		}
+		e.lastIds[cmd.Id] = true
+		c.Unlock()
+		return false
+	}
+
+	e.lastTimestamp = cmd.Timestamp
+	e.lastIds = map[uint64]bool{cmd.Id: true}
+	changed := cmd.Timestamp > c.lastTimestamp
+	if changed {
+		c.lastTimestamp = cmd.Timestamp
+	}
+	status := c.status()
+	c.Unlock()
+
+	if changed && c.onChange != nil {
+		c.onChange(status, false)
+	}
+	return false
+}
+
+// update connection state
+func (c *connection) setState(state string, s *session, err error) {
+	c.Lock()
+	c.session = s
+	if err != nil {
+		c.lastError = err.Error()
+	}
+
+	transition := c.state != state
+	if transition {
+		if err != nil {
+			connLog.Infof("connection state: %s -> %s (error: %s)", c.state, state, err)
+		} else {
+			connLog.Infof("connection state: %s -> %s", c.state, state)
+		}
+		c.state = state
+		if state == StateConnected {
+			c.connectedAt = time.Now()
+		} else {
+			c.connectedAt = time.Time{}
+		}
+	}
+	status := c.status()
+	c.Unlock()
+
+	if c.onChange != nil && (transition || err != nil) {
+		c.onChange(status, transition)
+	}
+}
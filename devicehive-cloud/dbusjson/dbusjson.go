@@ -0,0 +1,170 @@
+package dbusjson
+
+// Conversion between D-Bus values and JSON values
+// (as produced by encoding/json with interface{} target).
+//
+// D-Bus -> JSON:
+//   b                    -> boolean
+//   y, n, q, i, u, x, t  -> number (integer)
+//   d                    -> number
+//   s, o, g              -> string
+//   v                    -> value of the variant
+//   array, struct        -> array
+//   dict                 -> object (keys are converted to strings)
+//
+// JSON -> D-Bus:
+//   boolean              -> b
+//   number               -> x (integers) or d
+//   string               -> s
+//   array                -> av
+//   object               -> a{sv}
+//   null                 -> not supported (null fields are omitted in objects)
+//
+// So JSON -> D-Bus -> JSON round trip is lossless for null-free values.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/godbus/dbus"
+)
+
+var (
+	ErrNull = errors.New("null cannot be represented in D-Bus")
+)
+
+// ToJSON converts D-Bus value to JSON compatible value
+func ToJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case dbus.Variant:
+		return ToJSON(t.Value())
+	case dbus.ObjectPath:
+		return string(t), nil
+	case dbus.Signature:
+		return t.String(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), nil
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+
+	case reflect.Slice, reflect.Array:
+		res := make([]interface{}, rv.Len())
+		for i := range res {
+			item, err := ToJSON(rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			res[i] = item
+		}
+		return res, nil
+
+	case reflect.Map:
+		res := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			item, err := ToJSON(rv.MapIndex(key).Interface())
+			if err != nil {
+				return nil, err
+			}
+			res[fmt.Sprint(key.Interface())] = item
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unsupported D-Bus value of type %T", v)
+}
+
+// FromJSON converts JSON value to D-Bus variant
+func FromJSON(v interface{}) (dbus.Variant, error) {
+	switch t := v.(type) {
+	case nil:
+		return dbus.Variant{}, ErrNull
+	case bool, string:
+		return dbus.MakeVariant(t), nil
+	case int64:
+		return dbus.MakeVariant(t), nil
+	case uint64:
+		if t > math.MaxInt64 {
+			return dbus.MakeVariant(float64(t)), nil
+		}
+		return dbus.MakeVariant(int64(t)), nil
+	case float64:
+		if t == math.Trunc(t) && math.Abs(t) < (1<<53) {
+			return dbus.MakeVariant(int64(t)), nil
+		}
+		return dbus.MakeVariant(t), nil
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return dbus.MakeVariant(i), nil
+		}
+		f, err := t.Float64()
+		if err != nil {
+			return dbus.Variant{}, err
+		}
+		return dbus.MakeVariant(f), nil
+
+	case []interface{}:
+		res := make([]dbus.Variant, 0, len(t))
+		for i, item := range t {
+			variant, err := FromJSON(item)
+			if err != nil {
+				return dbus.Variant{}, fmt.Errorf("[%d]: %s", i, err)
+			}
+			res = append(res, variant)
+		}
+		return dbus.MakeVariant(res), nil
+
+	case map[string]interface{}:
+		res, err := ObjectFromJSON(t)
+		if err != nil {
+			return dbus.Variant{}, err
+		}
+		return dbus.MakeVariant(res), nil
+	}
+
+	return dbus.Variant{}, fmt.Errorf("unsupported JSON value of type %T", v)
+}
+
+// ObjectFromJSON converts JSON value to a{sv} dictionary
+// null is converted to empty dictionary, null fields are omitted
+// and non-object values are wrapped as {"value": v}
+func ObjectFromJSON(v interface{}) (map[string]dbus.Variant, error) {
+	res := make(map[string]dbus.Variant)
+	switch t := v.(type) {
+	case nil:
+		return res, nil
+	case map[string]interface{}:
+		for key, item := range t {
+			if item == nil {
+				continue
+			}
+			variant, err := FromJSON(item)
+			if err != nil {
+				return nil, fmt.Errorf("%q: %s", key, err)
+			}
+			res[key] = variant
+		}
+		return res, nil
+	}
+
+	variant, err := FromJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	res["value"] = variant
+	return res, nil
+}
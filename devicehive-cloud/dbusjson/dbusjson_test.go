@@ -0,0 +1,165 @@
+package dbusjson
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/godbus/dbus"
+)
+
+// pass values through D-Bus wire format
+func wire(t *testing.T, values ...interface{}) []interface{} {
+	msg := &dbus.Message{
+		Type: dbus.TypeSignal,
+		Headers: map[dbus.HeaderField]dbus.Variant{
+			dbus.FieldPath:      dbus.MakeVariant(dbus.ObjectPath("/com/devicehive/cloud")),
+			dbus.FieldInterface: dbus.MakeVariant("com.devicehive.cloud"),
+			dbus.FieldMember:    dbus.MakeVariant("Test"),
+			dbus.FieldSignature: dbus.MakeVariant(dbus.SignatureOf(values...)),
+		},
+		Body: values,
+	}
+
+	var buf bytes.Buffer
+	if err := msg.EncodeTo(&buf, binary.LittleEndian); err != nil {
+		t.Fatal(err)
+	}
+	res, err := dbus.DecodeMessage(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.Body
+}
+
+// decode JSON preserving integers
+func decode(t *testing.T, s string) interface{} {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewBufferString(s))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// compare JSON representations
+func assertSameJSON(t *testing.T, expected string, v interface{}) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var a, b interface{}
+	json.Unmarshal([]byte(expected), &a)
+	json.Unmarshal(buf, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("expected %s, got %s", expected, buf)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	samples := []string{
+		`{}`,
+		`{"mac": "b4994c6433be", "rssi": -67, "connected": true}`,
+		`{"value": 21.5, "count": 9007199254740991, "neg": -1}`,
+		`{"list": [1, "two", 3.5, false, [], {}], "nested": {"a": {"b": {"c": "d"}}}}`,
+		`{"empty": "", "unicode": "°C"}`,
+	}
+
+	for _, s := range samples {
+		params, err := ObjectFromJSON(decode(t, s))
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+
+		body := wire(t, params)
+		res, err := ToJSON(body[0])
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		assertSameJSON(t, s, res)
+	}
+}
+
+func TestScalarRoundTrip(t *testing.T) {
+	samples := []string{`true`, `"text"`, `42`, `-42`, `0.125`, `[1, [2, [3]]]`}
+
+	for _, s := range samples {
+		variant, err := FromJSON(decode(t, s))
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+
+		body := wire(t, variant)
+		res, err := ToJSON(body[0])
+		if err != nil {
+			t.Errorf("%s: %s", s, err)
+			continue
+		}
+		assertSameJSON(t, s, res)
+	}
+}
+
+func TestDBusTypes(t *testing.T) {
+	samples := []struct {
+		value    interface{}
+		expected string
+	}{
+		{byte(7), `7`},
+		{int16(-3), `-3`},
+		{uint32(4000000000), `4000000000`},
+		{uint64(1) << 63, `9223372036854775808`},
+		{dbus.ObjectPath("/com/devicehive/cloud"), `"/com/devicehive/cloud"`},
+		{[]string{"a", "b"}, `["a", "b"]`},
+		{[]byte{1, 2}, `[1, 2]`},
+		{map[string]string{"k": "v"}, `{"k": "v"}`},
+		{map[uint32]bool{1: true}, `{"1": true}`},
+		{[]interface{}{"struct", int32(1)}, `["struct", 1]`},
+		{dbus.MakeVariant(map[string]dbus.Variant{"v": dbus.MakeVariant(1.5)}), `{"v": 1.5}`},
+	}
+
+	for _, s := range samples {
+		body := wire(t, s.value)
+		res, err := ToJSON(body[0])
+		if err != nil {
+			t.Errorf("%T: %s", s.value, err)
+			continue
+		}
+		assertSameJSON(t, s.expected, res)
+	}
+}
+
+func TestNull(t *testing.T) {
+	if _, err := FromJSON(nil); err != ErrNull {
+		t.Errorf("null should not be converted, got %v", err)
+	}
+	if _, err := FromJSON([]interface{}{nil}); err == nil {
+		t.Errorf("null array item should not be converted")
+	}
+
+	params, err := ObjectFromJSON(decode(t, `{"a": null, "b": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params["a"]; ok || len(params) != 1 {
+		t.Errorf("null field should be omitted, got %v", params)
+	}
+
+	params, err = ObjectFromJSON(nil)
+	if err != nil || len(params) != 0 {
+		t.Errorf("null should be converted to empty dictionary, got %v (%v)", params, err)
+	}
+
+	params, err = ObjectFromJSON(decode(t, `[1]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _ := ToJSON(params)
+	assertSameJSON(t, `{"value": [1]}`, res)
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 
+	"github.com/devicehive/IoT-framework/devicehive-cloud/backend"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/backend/mqtt"
 	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/mockserver"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/transport"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
 	"github.com/devicehive/devicehive-go/devicehive"
-	"github.com/devicehive/devicehive-go/devicehive/log"
 
 	"github.com/godbus/dbus"
+	"gopkg.in/yaml.v2"
 )
 
 // parse a string to custom JSON
@@ -29,18 +38,58 @@ const (
 )
 
 func main() {
+	log.SetService("devicehive-cloud")
+	log.SetOutput(io.MultiWriter(os.Stderr, logRing))
+	if configFile := conf.FileFromArgs(); len(configFile) != 0 {
+		// the service is restarted during remote configuration change
+		if restored, err := conf.Rollback(configFile); err != nil {
+			log.Warnf("Failed to roll back unconfirmed configuration change (error: %s)", err)
+		} else if restored {
+			log.Warnf("Unconfirmed configuration change is rolled back")
+		}
+	}
 	configFile, config, err := conf.FromArgs()
 	switch {
 	case err != nil:
 		log.Fatalf("Failed to read %q configuration (%s)", configFile, err)
-	case configFile == "":
+	case conf.PrintFromArgs():
+		printConfig(config)
+		return
+	case conf.DumpHistoryFromArgs():
+		dumpHistory(config)
+		return
+	}
+
+	if err = applyLogging(config); err != nil {
+		log.Fatalf("Invalid logging configuration (%s)", err)
+	}
+	if len(configFile) == 0 {
 		log.Warnf("No configuration file provided!")
-		log.Infof("Test configuration is used: %+v", config)
-	default:
-		log.Infof("Starting DeviceHive with %q configuration: %+v", configFile, config)
+		log.Infof("Test configuration is used: %+v", config.Redacted())
+	} else {
+		log.Infof("Starting DeviceHive with %q configuration: %+v", configFile, config.Redacted())
 	}
 
-	log.SetLevelByName(config.LoggingLevel)
+	local, addr, scenario := conf.LocalFromArgs()
+	if local {
+		config.Backend = "devicehive"
+		if config.URL, err = startMockServer(addr, scenario, config.DeviceID); err != nil {
+			log.Fatalf("Cannot start mock DeviceHive server (error: %s)", err)
+		}
+	}
+
+	// configuration is read again on reload
+	loadConfig := func() (conf.Conf, error) {
+		if len(configFile) == 0 {
+			return conf.Conf{}, errors.New("no configuration file provided")
+		}
+		c, err := conf.Read(configFile)
+		if err == nil && local {
+			c.Backend = config.Backend
+			c.URL = config.URL // embedded mock server
+		}
+		return c, err
+	}
 
 	bus, err := dbus.SystemBus()
 	if err != nil {
@@ -60,11 +109,62 @@ func main() {
 		log.Fatalf("The name %q already taken", DBusConnName)
 	}
 
-	s, err := devicehive.NewService(config.URL, config.AccessKey)
-	if err != nil {
-		log.Fatalf("Failed to create DeviceHive service (error: %s)", err)
+	newService := func(config conf.Conf) (backend.Backend, error) {
+		tlsConfig, err := transport.TLSConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		proxy, err := transport.ProxyFunc(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		t := transport.New(tlsConfig, proxy)
+		switch config.Backend {
+		case "devicehive":
+			// the library connects with default transports only,
+			// the previous session is over (see connection.connect)
+			transport.UseByDefault(t)
+			s, err := devicehive.NewService(config.URL, config.AccessKey)
+			if err == nil {
+				log.Infof("Starting %v", s)
+			}
+			return s, err
+		case "mqtt":
+			if len(config.MQTT.ClientID) == 0 {
+				config.MQTT.ClientID = config.DeviceID
+			}
+			return mqtt.New(config.MQTT, t)
+		}
+		return nil, fmt.Errorf("unknown backend %q", config.Backend)
+	}
+
+	mainLoop(bus, config, configFile, newService, loadConfig)
+}
+
+// start embedded mock DeviceHive server and run scenario (if any)
+func startMockServer(addr, scenario, deviceId string) (url string, err error) {
+	var steps []mockserver.Step
+	if len(scenario) != 0 {
+		if steps, err = mockserver.LoadScenario(scenario); err != nil {
+			return
+		}
 	}
 
-	log.Infof("Starting %v", s)
-	mainLoop(bus, s, config)
+	server := mockserver.New()
+	if url, err = server.Start(addr); err != nil {
+		return
+	}
+
+	go server.RunScenario(steps, deviceId)
+	return
+}
+
+// print effective configuration in Yaml, secrets are redacted
+func printConfig(config conf.Conf) {
+	buf, err := yaml.Marshal(config.Redacted())
+	if err != nil {
+		log.Fatalf("Cannot print configuration (error: %s)", err)
+	}
+	os.Stdout.Write(buf)
 }
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/devicehive/devicehive-go/devicehive"
+	"github.com/devicehive/devicehive-go/devicehive/core"
+
+	"github.com/godbus/dbus"
+	"github.com/godbus/dbus/introspect"
+)
+
+const (
+	ComDevicehiveCloudDevicesPath = ComDevicehiveCloudPath + "/devices"
+)
+
+// DBus object of a proxied device
+type DeviceObject struct {
+	w  *DBusWrapper
+	id string
+}
+
+// send notification on behalf of the device
+func (d *DeviceObject) SendNotification(name, parameters string, priority uint64) *dbus.Error {
+	return d.w.sendNotification(d.id, name, parameters, priority)
+}
+
+// update command result of the device
+func (d *DeviceObject) UpdateCommand(id uint64, status, result string) *dbus.Error {
+	return d.w.updateCommand(d.id, id, status, result)
+}
+
+// send notification with typed parameters on behalf of the device
+func (d *DeviceObject) SendNotificationV(name string, parameters map[string]dbus.Variant, priority uint64) *dbus.Error {
+	return d.w.sendNotificationV(d.id, name, parameters, priority)
+}
+
+// update command with typed result of the device
+func (d *DeviceObject) UpdateCommandV(id uint64, status string, result dbus.Variant) *dbus.Error {
+	return d.w.updateCommandV(d.id, id, status, result)
+}
+
+// update description of the device
+func (d *DeviceObject) UpdateDeviceInfo(info string) *dbus.Error {
+	return d.w.updateDeviceInfo(d.id, info)
+}
+
+// update description of the gateway
+// info is a JSON object with optional fields (only specified fields are replaced):
+// "equipment" - list of {"code", "name", "type", "data"} and "data" - free-form device data
+func (w *DBusWrapper) UpdateDeviceInfo(info string) *dbus.Error {
+	return w.updateDeviceInfo("", info)
+}
+
+// update device description and register it again
+func (w *DBusWrapper) updateDeviceInfo(deviceId, info string) *dbus.Error {
+	devicesLog.Infof("updating device info(device=%q, info=%q)", deviceId, info)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(strings.Trim(info, "\x00")), &fields); err != nil {
+		devicesLog.Warnf("failed to parse device info (error: %s)", err)
+		return newDHError(err.Error())
+	}
+
+	var equipment []*core.Equipment
+	var data interface{}
+	for name, value := range fields {
+		var err error
+		switch name {
+		case "equipment":
+			if err = json.Unmarshal(value, &equipment); err == nil {
+				for i, e := range equipment {
+					if e == nil || len(e.Code) == 0 {
+						err = fmt.Errorf("equipment #%d: code is required", i)
+						break
+					}
+				}
+			}
+		case "data":
+			err = json.Unmarshal(value, &data)
+		default:
+			err = fmt.Errorf("unknown field %q", name)
+		}
+		if err != nil {
+			devicesLog.Warnf("invalid device info (error: %s)", err)
+			return newDHError(err.Error())
+		}
+	}
+
+	err := w.conn.UpdateDevice(deviceId, func(device *core.Device) {
+		if _, ok := fields["equipment"]; ok {
+			if device.DeviceClass == nil {
+				device.DeviceClass = devicehive.NewDeviceClass("go-device-class", "0.1")
+			}
+			device.DeviceClass.Equipment = equipment
+		}
+		if _, ok := fields["data"]; ok {
+			device.Data = data
+		}
+	})
+	if err != nil {
+		devicesLog.Warnf("failed to update device %q (error: %s)", deviceId, err)
+		return newDHError(err.Error())
+	}
+
+	return nil // OK
+}
+
+// register proxied device
+// classJSON is a device class in DeviceHive JSON format, may be empty
+func (w *DBusWrapper) RegisterDevice(id, name, classJSON string) (dbus.ObjectPath, *dbus.Error) {
+	devicesLog.Infof("registering device(id=%q, name=%q, class=%q)", id, name, classJSON)
+	if len(id) == 0 || id == w.conn.Config().DeviceID {
+		return "", newDHError(fmt.Sprintf("invalid device identifier %q", id))
+	}
+
+	class := devicehive.NewDeviceClass("go-device-class", "0.1")
+	if len(strings.Trim(classJSON, "\x00 ")) != 0 {
+		class = new(core.DeviceClass)
+		if err := json.Unmarshal([]byte(strings.Trim(classJSON, "\x00")), class); err != nil {
+			devicesLog.Warnf("failed to parse device class (error: %s)", err)
+			return "", newDHError(err.Error())
+		}
+		if len(class.Name) == 0 || len(class.Version) == 0 {
+			return "", newDHError("device class name and version are required")
+		}
+	}
+
+	// commands may arrive as soon as the device is added
+	path := devicePath(id)
+	w.devicesMutex.Lock()
+	if _, ok := w.devices[id]; ok {
+		w.devicesMutex.Unlock()
+		return "", newDHError(errDeviceExists.Error())
+	}
+	w.devices[id] = path
+	w.devicesMutex.Unlock()
+
+	device := devicehive.NewDevice(id, name, class)
+	if err := w.conn.AddDevice(device); err != nil {
+		devicesLog.Warnf("failed to register device %q (error: %s)", id, err)
+		w.devicesMutex.Lock()
+		delete(w.devices, id)
+		w.devicesMutex.Unlock()
+		return "", newDHError(err.Error())
+	}
+
+	w.exportDeviceObject(&DeviceObject{w: w, id: id}, path)
+	w.exportDevicesNode()
+
+	return path, nil // OK
+}
+
+// unregister proxied device
+func (w *DBusWrapper) UnregisterDevice(id string) *dbus.Error {
+	devicesLog.Infof("unregistering device(id=%q)", id)
+
+	w.devicesMutex.Lock()
+	path, ok := w.devices[id]
+	delete(w.devices, id)
+	w.devicesMutex.Unlock()
+	if !ok {
+		return newDHError(fmt.Sprintf("unknown device %q", id))
+	}
+
+	w.conn.RemoveDevice(id) // stops command subscription
+	w.handlersMutex.Lock()
+	for key := range w.handlers {
+		if key.deviceId == id {
+			delete(w.handlers, key)
+		}
+	}
+	w.handlersMutex.Unlock()
+	w.bus.Export(nil, path, ComDevicehiveCloudIface)
+	w.bus.Export(nil, path, "org.freedesktop.DBus.Introspectable")
+	w.exportDevicesNode()
+
+	return nil // OK
+}
+
+// get D-Bus object path of the device, empty identifier means the gateway
+func (w *DBusWrapper) devicePath(deviceId string) dbus.ObjectPath {
+	if len(deviceId) == 0 {
+		return ComDevicehiveCloudPath
+	}
+
+	w.devicesMutex.Lock()
+	defer w.devicesMutex.Unlock()
+	return w.devices[deviceId]
+}
+
+// export proxied device object
+func (w *DBusWrapper) exportDeviceObject(d *DeviceObject, path dbus.ObjectPath) {
+	w.bus.Export(d, path, ComDevicehiveCloudIface)
+
+	n := &introspect.Node{
+		Name: string(path),
+		Interfaces: []introspect.Interface{
+			introspect.IntrospectData,
+			{
+				Name:    ComDevicehiveCloudIface,
+				Methods: introspect.Methods(d),
+				Signals: []introspect.Signal{
+					{
+						Name: "CommandReceived",
+						Args: []introspect.Arg{
+							{"id", "t", "out"},
+							{"name", "s", "out"},
+							{"parameters", "s", "out"}, // JSON string
+						},
+					},
+					{
+						Name: "CommandReceivedV",
+						Args: []introspect.Arg{
+							{"id", "t", "out"},
+							{"name", "s", "out"},
+							{"parameters", "a{sv}", "out"},
+						},
+					},
+				},
+			},
+		},
+	}
+	n_obj := introspect.NewIntrospectable(n)
+	devicesLog.Tracef("%q introspectable: %s", path, n_obj)
+	w.bus.Export(n_obj, path, "org.freedesktop.DBus.Introspectable")
+}
+
+// export devices container node listing all proxied devices
+func (w *DBusWrapper) exportDevicesNode() {
+	w.devicesMutex.Lock()
+	children := make([]introspect.Node, 0, len(w.devices))
+	for _, path := range w.devices {
+		name := strings.TrimPrefix(string(path), ComDevicehiveCloudDevicesPath+"/")
+		children = append(children, introspect.Node{Name: name})
+	}
+	w.devicesMutex.Unlock()
+	sort.Sort(byNodeName(children))
+
+	n := &introspect.Node{
+		Name:     ComDevicehiveCloudDevicesPath,
+		Children: children,
+	}
+	w.bus.Export(introspect.NewIntrospectable(n), ComDevicehiveCloudDevicesPath, "org.freedesktop.DBus.Introspectable")
+}
+
+// build D-Bus object path of the proxied device
+// all characters except [A-Za-z0-9] are escaped as _XX
+func devicePath(id string) dbus.ObjectPath {
+	var buf []byte
+	for _, b := range []byte(id) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+			buf = append(buf, b)
+		default:
+			buf = append(buf, fmt.Sprintf("_%02x", b)...)
+		}
+	}
+	return dbus.ObjectPath(ComDevicehiveCloudDevicesPath + "/" + string(buf))
+}
+
+// sort introspection nodes by name
+type byNodeName []introspect.Node
+
+func (a byNodeName) Len() int           { return len(a) }
+func (a byNodeName) Less(i, j int) bool { return a[i].Name < a[j].Name }
+func (a byNodeName) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
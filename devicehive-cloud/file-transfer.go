@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/filetransfer"
+)
+
+var (
+	errFilesDisabled = errors.New("file transfer is disabled (no FileDir configured)")
+	errFilesBusy     = errors.New("file transfer is busy, try again later")
+)
+
+// start file transfer: {"name": "rules/a.json", "size": 1024, "sha256": "..."}
+// returns offset of the next chunk (not zero if the transfer is resumed)
+func (w *DBusWrapper) gatewayFileBegin(params interface{}) (interface{}, error) {
+	args := struct {
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		SHA256 string `json:"sha256"`
+	}{}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	if w.files == nil {
+		return nil, errFilesDisabled
+	}
+
+	offset, err := w.files.Begin(args.Name, args.Size, args.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	filesLog.Infof("receiving file %q (size: %d, offset: %d)", args.Name, args.Size, offset)
+	return map[string]interface{}{"offset": offset}, nil
+}
+
+// write file chunk: {"name": "rules/a.json", "offset": 0, "data": "<base64>", "sha256": "..."}
+// SHA-256 of the chunk is optional, returns offset of the next chunk
+// (the expected one is returned along with error for chunk at other offset)
+func (w *DBusWrapper) gatewayFileChunk(params interface{}) (interface{}, error) {
+	args := struct {
+		Name   string `json:"name"`
+		Offset int64  `json:"offset"`
+		Data   string `json:"data"`
+		SHA256 string `json:"sha256"`
+	}{}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	if w.files == nil {
+		return nil, errFilesDisabled
+	}
+
+	data, err := base64.StdEncoding.DecodeString(args.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chunk data (%s)", err)
+	}
+	if len(args.SHA256) != 0 {
+		h := sha256.Sum256(data)
+		if sum := hex.EncodeToString(h[:]); sum != strings.ToLower(args.SHA256) {
+			return nil, fmt.Errorf("chunk SHA-256 mismatch (%s received, %s expected)", sum, args.SHA256)
+		}
+	}
+
+	offset, err := w.files.Write(args.Name, args.Offset, data)
+	if _, ok := err.(*filetransfer.OffsetError); ok {
+		return map[string]interface{}{"offset": offset}, err
+	} else if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"offset": offset}, nil
+}
+
+// verify received file and move it into place: {"name": "rules/a.json"}
+func (w *DBusWrapper) gatewayFileCommit(params interface{}) (interface{}, error) {
+	args := struct {
+		Name string `json:"name"`
+	}{}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	if w.files == nil {
+		return nil, errFilesDisabled
+	}
+
+	path, size, err := w.files.Commit(args.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	filesLog.Infof("file %q is received (size: %d)", path, size)
+	filesLog.Debugf("emitting FileReceived(%q, %q, %d)", args.Name, path, size)
+	w.bus.Emit(ComDevicehiveCloudPath, ComDevicehiveCloudIface+".FileReceived", args.Name, path, uint64(size))
+	return map[string]interface{}{"path": path, "size": size}, nil
+}
@@ -0,0 +1,207 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	partExt = ".part" // received data
+	metaExt = ".meta" // expected size and hash
+)
+
+var (
+	ErrInvalidName = errors.New("file name should be relative and should not contain hidden parts")
+	ErrNotStarted  = errors.New("file transfer is not started")
+	ErrTooLarge    = errors.New("file is too large")
+)
+
+// OffsetError is returned for chunk at unexpected offset
+type OffsetError struct {
+	Offset   int64 // of the chunk
+	Expected int64 // offset of the next chunk
+}
+
+func (e *OffsetError) Error() string {
+	return fmt.Sprintf("unexpected offset %d, expected %d", e.Offset, e.Expected)
+}
+
+// expected file, stored next to received data to resume after restart
+type meta struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"` // hex
+}
+
+// Receiver writes files into the sandbox directory.
+// Data is appended to hidden .part file which is renamed on commit,
+// so incomplete files are never seen by applications.
+type Receiver struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+// New creates the sandbox directory (if needed), zero maxSize means no limit
+func New(dir string, maxSize int64) (*Receiver, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &Receiver{dir: dir, maxSize: maxSize}, nil
+}
+
+// Begin starts (or resumes) the transfer, returns offset of the next chunk
+// the transfer is restarted if size or hash is changed
+func (r *Receiver) Begin(name string, size int64, sum string) (int64, error) {
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil || len(sum) != 2*sha256.Size {
+		return 0, fmt.Errorf("invalid SHA-256 %q", sum)
+	}
+	if size < 0 || (r.maxSize > 0 && size > r.maxSize) {
+		return 0, ErrTooLarge
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path, err := r.path(name)
+	if err != nil {
+		return 0, err
+	}
+	if m, err := readMeta(path); err == nil && m.Size == size && m.SHA256 == sum {
+		if info, err := os.Stat(hidden(path, partExt)); err == nil && info.Size() <= size {
+			return info.Size(), nil // resume
+		}
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	if err = ioutil.WriteFile(hidden(path, partExt), nil, 0644); err != nil {
+		return 0, err
+	}
+	buf, _ := json.Marshal(meta{Size: size, SHA256: sum})
+	if err = ioutil.WriteFile(hidden(path, metaExt), buf, 0644); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Write appends the chunk at the offset, returns offset of the next chunk
+// chunks at unexpected offset are rejected (the expected one is returned)
+func (r *Receiver) Write(name string, offset int64, data []byte) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path, err := r.path(name)
+	if err != nil {
+		return 0, err
+	}
+	m, err := readMeta(path)
+	if err != nil {
+		return 0, ErrNotStarted
+	}
+	f, err := os.OpenFile(hidden(path, partExt), os.O_WRONLY, 0)
+	if err != nil {
+		return 0, ErrNotStarted
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case offset != info.Size():
+		return info.Size(), &OffsetError{Offset: offset, Expected: info.Size()}
+	case offset+int64(len(data)) > m.Size:
+		return info.Size(), ErrTooLarge
+	}
+
+	if _, err = f.WriteAt(data, offset); err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Truncate(offset) // partial chunk is written again
+		return offset, err
+	}
+	return offset + int64(len(data)), nil
+}
+
+// Commit verifies size and SHA-256 of received data and moves the file
+// into place, returns path of the file
+func (r *Receiver) Commit(name string) (path string, size int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if path, err = r.path(name); err != nil {
+		return
+	}
+	m, err := readMeta(path)
+	if err != nil {
+		return "", 0, ErrNotStarted
+	}
+	f, err := os.Open(hidden(path, partExt))
+	if err != nil {
+		return "", 0, ErrNotStarted
+	}
+	h := sha256.New()
+	size, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return
+	}
+
+	switch sum := hex.EncodeToString(h.Sum(nil)); {
+	case size != m.Size:
+		return "", size, fmt.Errorf("%d bytes received, %d expected", size, m.Size)
+	case sum != m.SHA256:
+		// corrupted, should be sent again
+		os.Remove(hidden(path, partExt))
+		os.Remove(hidden(path, metaExt))
+		return "", size, fmt.Errorf("SHA-256 mismatch (%s received, %s expected)", sum, m.SHA256)
+	}
+
+	if err = os.Rename(hidden(path, partExt), path); err != nil {
+		return
+	}
+	os.Remove(hidden(path, metaExt))
+	return
+}
+
+// get path of the file inside the sandbox
+func (r *Receiver) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if len(name) == 0 || filepath.IsAbs(clean) {
+		return "", ErrInvalidName
+	}
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		if strings.HasPrefix(part, ".") {
+			return "", ErrInvalidName // also ".."
+		}
+	}
+	return filepath.Join(r.dir, clean), nil
+}
+
+// get path of the hidden file next to the file
+func hidden(path, ext string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+ext)
+}
+
+// read expected size and hash
+func readMeta(path string) (m meta, err error) {
+	buf, err := ioutil.ReadFile(hidden(path, metaExt))
+	if err == nil {
+		err = json.Unmarshal(buf, &m)
+	}
+	return
+}
@@ -0,0 +1,89 @@
+package filetransfer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTransfer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := []byte("hello, gateway")
+	h := sha256.Sum256(data)
+	sum := hex.EncodeToString(h[:])
+
+	r, err := New(dir, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset, err := r.Begin("rules/a.json", int64(len(data)), sum); err != nil || offset != 0 {
+		t.Fatalf("Begin: %d, %v", offset, err)
+	}
+	if offset, err := r.Write("rules/a.json", 0, data[:5]); err != nil || offset != 5 {
+		t.Fatalf("Write: %d, %v", offset, err)
+	}
+	if offset, err := r.Write("rules/a.json", 2, data[2:]); err == nil || offset != 5 {
+		t.Fatalf("unexpected offset should be rejected: %d, %v", offset, err)
+	} else if e, ok := err.(*OffsetError); !ok || e.Offset != 2 || e.Expected != 5 {
+		t.Fatalf("unexpected error %#v", err)
+	}
+
+	// resumed by another receiver (ex: after restart)
+	r, _ = New(dir, 1024)
+	offset, err := r.Begin("rules/a.json", int64(len(data)), sum)
+	if err != nil || offset != 5 {
+		t.Fatalf("Begin should resume: %d, %v", offset, err)
+	}
+	if _, err = r.Write("rules/a.json", offset, data[offset:]); err != nil {
+		t.Fatal(err)
+	}
+	path, size, err := r.Commit("rules/a.json")
+	if err != nil || size != int64(len(data)) || path != filepath.Join(dir, "rules", "a.json") {
+		t.Fatalf("Commit: %q, %d, %v", path, size, err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != string(data) {
+		t.Fatalf("file content %q", got)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Fatalf("temporary files are left: %d files", len(files))
+	}
+}
+
+func TestCommitMismatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filetransfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, _ := New(dir, 0)
+	sum := hex.EncodeToString(make([]byte, sha256.Size))
+	r.Begin("a", 3, sum)
+	r.Write("a", 0, []byte("abc"))
+	if _, _, err := r.Commit("a"); err == nil {
+		t.Fatal("SHA-256 mismatch expected")
+	}
+	if _, err := r.Write("a", 0, []byte("abc")); err != ErrNotStarted {
+		t.Fatalf("transfer should be restarted, got %v", err)
+	}
+}
+
+func TestInvalidNames(t *testing.T) {
+	r := &Receiver{dir: "/sandbox"}
+	for _, name := range []string{"", "/etc/passwd", "../a", "a/../../b", ".a", "a/.b.part"} {
+		if _, err := r.path(name); err != ErrInvalidName {
+			t.Errorf("%q should be rejected", name)
+		}
+	}
+	if path, err := r.path("a/./b"); err != nil || path != "/sandbox/a/b" {
+		t.Errorf("a/./b: %q, %v", path, err)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+)
+
+const (
+	// commands of the gateway device answered by the daemon itself
+	gatewayCommandPrefix = "gateway/"
+
+	// file transfer commands are handled one by one in order of arrival
+	fileCommandPrefix    = "gateway/file/"
+	fileCommandsCapacity = 64
+
+	// lines kept for gateway/logs
+	logRingSize = 1000
+
+	// D-Bus services listed by gateway/info and gateway/services
+	servicePrefix = "com.devicehive."
+)
+
+var (
+	// set on build: -ldflags "-X main.version=..."
+	version = "dev"
+
+	// when the daemon is started
+	started = time.Now()
+
+	// recent log records for gateway/logs
+	logRing = log.NewRing(logRingSize)
+)
+
+// built-in command handler, returns command result
+type gatewayHandler func(w *DBusWrapper, params interface{}) (interface{}, error)
+
+// built-in commands by name
+var gatewayCommands = map[string]gatewayHandler{
+	"gateway/info":     (*DBusWrapper).gatewayInfo,
+	"gateway/ping":     (*DBusWrapper).gatewayPing,
+	"gateway/logs":     (*DBusWrapper).gatewayLogs,
+	"gateway/services": (*DBusWrapper).gatewayServices,
+
+	"gateway/config/set":  (*DBusWrapper).gatewayConfigSet,
+	"gateway/file/begin":  (*DBusWrapper).gatewayFileBegin,
+	"gateway/file/chunk":  (*DBusWrapper).gatewayFileChunk,
+	"gateway/file/commit": (*DBusWrapper).gatewayFileCommit,
+}
+
+// answer built-in command of the gateway device
+// returns false if the command is not built-in
+func (w *DBusWrapper) gatewayCommand(cmd deviceCommand) bool {
+	if len(cmd.DeviceId) != 0 || !strings.HasPrefix(cmd.Name, gatewayCommandPrefix) {
+		return false
+	}
+
+	clog := commandsLog.With("command", cmd.Id)
+	handler, ok := gatewayCommands[cmd.Name]
+	if !ok {
+		clog.Warnf("unknown gateway command %q", cmd.Name)
+		w.answerCommand(cmd, nil, fmt.Errorf("unknown gateway command %q", cmd.Name))
+		return true
+	}
+
+	run := func() {
+		clog.Debugf("handling gateway command %q", cmd.Name)
+		result, err := handler(w, cmd.Parameters)
+		if err != nil {
+			clog.Warnf("gateway command %q failed (error: %s)", cmd.Name, err)
+		}
+		w.answerCommand(cmd, result, err)
+	}
+	if !strings.HasPrefix(cmd.Name, fileCommandPrefix) {
+		go run()
+		return true
+	}
+
+	// chunks are written in order, commands of other devices are not blocked
+	select {
+	case w.fileCommands <- run:
+	default:
+		clog.Warnf("gateway command %q failed (error: %s)", cmd.Name, errFilesBusy)
+		go w.answerCommand(cmd, nil, errFilesBusy)
+	}
+	return true
+}
+
+// handle file transfer commands in order
+func (w *DBusWrapper) fileLoop() {
+	for run := range w.fileCommands {
+		run()
+	}
+}
+
+// post result of the built-in command
+func (w *DBusWrapper) answerCommand(cmd deviceCommand, result interface{}, err error) {
+	status := commandStatusSuccess
+	if err != nil {
+		status = fmt.Sprintf(commandStatusError, err)
+	}
+	if err := w.postCommandResult(cmd.DeviceId, cmd.Id, status, result); err != nil {
+		commandsLog.With("command", cmd.Id).Warnf("failed to update command (error: %s)", err)
+	}
+}
+
+// decode command parameters into structure
+func decodeParams(params interface{}, v interface{}) error {
+	if params == nil {
+		return nil
+	}
+	buf, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(buf, v); err != nil {
+		return fmt.Errorf("invalid parameters (%s)", err)
+	}
+	return nil
+}
+
+// version, uptime, configuration summary and D-Bus services
+func (w *DBusWrapper) gatewayInfo(params interface{}) (interface{}, error) {
+	services, err := w.services()
+	if err != nil {
+		return nil, err
+	}
+
+	config := w.conn.Config()
+	status := w.conn.Status()
+	return map[string]interface{}{
+		"version": version,
+		"started": started.UTC().Format(timestampLayout),
+		"uptime":  uint64(time.Since(started).Seconds()),
+		"config": map[string]interface{}{
+			"backend":       config.Backend,
+			"url":           config.URL,
+			"deviceId":      config.DeviceID,
+			"deviceName":    config.DeviceName,
+			"network":       config.NetworkName,
+			"queueCapacity": config.SendNotificatonQueueCapacity,
+			"spool":         len(config.SpoolDir) != 0,
+			"loggingLevel":  config.LoggingLevel,
+		},
+		"connection": map[string]interface{}{
+			"state":         status.State,
+			"lastTimestamp": status.LastTimestamp,
+		},
+		"queueLength": w.queueLength(),
+		"services":    services,
+	}, nil
+}
+
+// check the gateway is alive
+func (w *DBusWrapper) gatewayPing(params interface{}) (interface{}, error) {
+	return map[string]interface{}{
+		"pong": time.Now().UTC().Format(timestampLayout),
+	}, nil
+}
+
+// the last log records: {"lines": 100}
+func (w *DBusWrapper) gatewayLogs(params interface{}) (interface{}, error) {
+	args := struct {
+		Lines int `json:"lines"`
+	}{Lines: 100}
+	if err := decodeParams(params, &args); err != nil {
+		return nil, err
+	}
+	return logRing.Tail(args.Lines), nil
+}
+
+// DeviceHive services present on the bus
+func (w *DBusWrapper) gatewayServices(params interface{}) (interface{}, error) {
+	return w.services()
+}
+
+// get com.devicehive.* names owned on the bus
+func (w *DBusWrapper) services() ([]string, error) {
+	var names []string
+	err := w.bus.BusObject().Call("org.freedesktop.DBus.ListNames", 0).Store(&names)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, servicePrefix) {
+			res = append(res, name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
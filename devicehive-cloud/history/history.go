@@ -0,0 +1,215 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const fileName = "history.log" // rotated to history.log.1, history.log.2, ...
+
+// kinds of entries
+const (
+	KindCommand      = "command"      // command is received
+	KindResult       = "result"       // command result is posted (or failed)
+	KindNotification = "notification" // notification is sent, failed or dropped
+)
+
+// notification statuses
+const (
+	StatusSent    = "sent"
+	StatusFailed  = "failed"
+	StatusDropped = "dropped"
+)
+
+// Entry is a journal record, one JSON object per line
+type Entry struct {
+	Time       time.Time   `json:"time"`
+	Kind       string      `json:"kind"`
+	Device     string      `json:"device,omitempty"` // empty for the gateway device
+	Id         uint64      `json:"id,omitempty"`     // command identifier
+	Name       string      `json:"name"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Latency    float64     `json:"latency,omitempty"` // command answer time in seconds
+	Reason     string      `json:"reason,omitempty"`  // error of failed or dropped entry
+}
+
+// Filter selects entries, empty fields match everything
+type Filter struct {
+	Kind   string
+	Device string
+	Name   string
+	Status string
+	Id     uint64
+	Since  time.Time
+}
+
+// check if the entry matches the filter
+func (f Filter) match(e *Entry) bool {
+	switch {
+	case len(f.Kind) != 0 && f.Kind != e.Kind,
+		len(f.Device) != 0 && f.Device != e.Device,
+		len(f.Name) != 0 && f.Name != e.Name,
+		len(f.Status) != 0 && f.Status != e.Status,
+		f.Id != 0 && f.Id != e.Id,
+		e.Time.Before(f.Since):
+		return false
+	}
+	return true
+}
+
+// Journal keeps entries in rotated files, the current file is rotated
+// when it exceeds maxSize, maxFiles files are kept (including the current one)
+type Journal struct {
+	dir      string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+
+	// files are append-only, readers are only blocked by rotation
+	rotating sync.RWMutex
+}
+
+// Open creates the journal directory (if needed) and opens the current file
+func Open(dir string, maxSize int64, maxFiles int) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if maxFiles < 1 {
+		maxFiles = 1
+	}
+
+	j := &Journal{dir: dir, maxSize: maxSize, maxFiles: maxFiles}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Add appends the entry
+func (j *Journal) Add(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	buf, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.maxSize > 0 && j.size > 0 && j.size+int64(len(buf)) > j.maxSize {
+		if err = j.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := j.file.Write(buf)
+	j.size += int64(n)
+	return err
+}
+
+// Query returns the last limit entries matching the filter (all if limit is zero),
+// the oldest first
+func (j *Journal) Query(filter Filter, limit int) ([]Entry, error) {
+	j.rotating.RLock()
+	defer j.rotating.RUnlock()
+	return Read(j.dir, filter, limit)
+}
+
+// Close closes the current file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Read reads entries of the journal directory (ex: of stopped daemon),
+// see Query
+func Read(dir string, filter Filter, limit int) ([]Entry, error) {
+	var paths []string
+	for i := 0; ; i++ {
+		path := filePath(dir, i)
+		if _, err := os.Stat(path); err != nil {
+			break
+		}
+		paths = append(paths, path)
+	}
+
+	res := []Entry{}
+	for i := len(paths) - 1; i >= 0; i-- {
+		entries, err := readFile(paths[i], filter)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, entries...)
+		if limit > 0 && len(res) > limit {
+			res = res[len(res)-limit:]
+		}
+	}
+	return res, nil
+}
+
+// read matching entries of the file, corrupted lines are skipped
+func readFile(path string, filter Filter) (res []Entry, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	s.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for s.Scan() {
+		var e Entry
+		if json.Unmarshal(s.Bytes(), &e) == nil && filter.match(&e) {
+			res = append(res, e)
+		}
+	}
+	return res, s.Err()
+}
+
+// open the current file for appending
+func (j *Journal) open() error {
+	f, err := os.OpenFile(filePath(j.dir, 0), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	j.file, j.size = f, info.Size()
+	return nil
+}
+
+// shift rotated files and start new current file
+func (j *Journal) rotate() error {
+	j.rotating.Lock()
+	defer j.rotating.Unlock()
+
+	j.file.Close()
+	os.Remove(filePath(j.dir, j.maxFiles-1))
+	for i := j.maxFiles - 2; i >= 0; i-- {
+		os.Rename(filePath(j.dir, i), filePath(j.dir, i+1))
+	}
+	return j.open()
+}
+
+// path of the current (0) or rotated file
+func filePath(dir string, i int) string {
+	if i == 0 {
+		return filepath.Join(dir, fileName)
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.%d", fileName, i))
+}
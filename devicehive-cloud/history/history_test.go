@@ -0,0 +1,60 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRotateAndQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	j, err := Open(dir, 200, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Unix(1000000, 0)
+	for i := 0; i < 20; i++ {
+		e := Entry{Time: start.Add(time.Duration(i) * time.Second), Kind: KindCommand, Id: uint64(i + 1), Name: "cmd"}
+		if i%2 == 1 {
+			e.Kind, e.Status = KindResult, "success"
+		}
+		if err = j.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the oldest files are removed
+	all, err := j.Query(Filter{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) == 0 || len(all) >= 20 || all[len(all)-1].Id != 20 {
+		t.Fatalf("unexpected entries: %+v", all)
+	}
+	for i := 1; i < len(all); i++ {
+		if all[i].Id != all[i-1].Id+1 {
+			t.Fatalf("entries are not in order: %+v", all)
+		}
+	}
+
+	res, _ := j.Query(Filter{Kind: KindResult}, 2)
+	if len(res) != 2 || res[0].Id != 18 || res[1].Id != 20 {
+		t.Fatalf("the last results expected: %+v", res)
+	}
+	res, _ = j.Query(Filter{Since: start.Add(18 * time.Second)}, 0)
+	if len(res) != 2 || res[0].Id != 19 {
+		t.Fatalf("entries since time expected: %+v", res)
+	}
+
+	// readable without the daemon
+	j.Close()
+	if res, _ = Read(dir, Filter{Id: 20}, 0); len(res) != 1 || res[0].Status != "success" {
+		t.Fatalf("entry by id expected: %+v", res)
+	}
+}
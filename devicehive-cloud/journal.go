@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/history"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+
+	"github.com/godbus/dbus"
+)
+
+// add entry to the history journal (if enabled)
+func (w *DBusWrapper) record(entry history.Entry) {
+	if w.journal == nil {
+		return
+	}
+	if err := w.journal.Add(entry); err != nil {
+		log.Warnf("failed to write history (error: %s)", err)
+	}
+}
+
+// command parameters kept in history and logs: secret settings
+// are redacted, file chunk data is omitted
+func commandParameters(cmd deviceCommand) interface{} {
+	params, ok := cmd.Parameters.(map[string]interface{})
+	if !ok || len(cmd.DeviceId) != 0 {
+		return cmd.Parameters
+	}
+
+	switch cmd.Name {
+	case "gateway/config/set":
+		return conf.RedactedPatch(params)
+	case "gateway/file/chunk":
+		res := make(map[string]interface{}, len(params))
+		for k, v := range params {
+			if k != "data" {
+				res[k] = v
+			}
+		}
+		return res
+	}
+	return cmd.Parameters
+}
+
+// get the last history entries as JSON array, the oldest first
+// filter keys: kind, device, name, status, id and since (RFC 3339),
+// zero limit returns all entries
+func (w *DBusWrapper) GetHistory(filter map[string]string, limit uint32) (string, *dbus.Error) {
+	if w.journal == nil {
+		return "", newDHError("history is disabled (no HistoryDir configured)")
+	}
+	f, err := historyFilter(filter)
+	if err != nil {
+		return "", newDHError(err.Error())
+	}
+
+	entries, err := w.journal.Query(f, int(limit))
+	if err != nil {
+		log.Warnf("failed to read history (error: %s)", err)
+		return "", newDHError(err.Error())
+	}
+	buf, err := json.Marshal(entries)
+	if err != nil {
+		return "", newDHError(err.Error())
+	}
+	return string(buf), nil
+}
+
+// parse D-Bus history filter
+func historyFilter(filter map[string]string) (f history.Filter, err error) {
+	for key, value := range filter {
+		switch key {
+		case "kind":
+			f.Kind = value
+		case "device":
+			f.Device = value
+		case "name":
+			f.Name = value
+		case "status":
+			f.Status = value
+		case "id":
+			if f.Id, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return f, fmt.Errorf("invalid command id %q", value)
+			}
+		case "since":
+			if f.Since, err = time.Parse(time.RFC3339, value); err != nil {
+				return f, fmt.Errorf("invalid time %q (RFC 3339 expected)", value)
+			}
+		default:
+			return f, fmt.Errorf("unknown history filter %q", key)
+		}
+	}
+	return
+}
+
+// print history journal as JSON lines
+func dumpHistory(config conf.Conf) {
+	if len(config.HistoryDir) == 0 {
+		log.Fatalf("History is disabled (no HistoryDir configured)")
+	}
+	entries, err := history.Read(config.HistoryDir, history.Filter{}, 0)
+	if err != nil {
+		log.Fatalf("Cannot read history %q (error: %s)", config.HistoryDir, err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	for _, e := range entries {
+		enc.Encode(e)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+	dhlog "github.com/devicehive/devicehive-go/devicehive/log"
+)
+
+// subsystem loggers, see LoggingLevels
+var (
+	connLog          = log.Component("connection")
+	notificationsLog = log.Component("notifications")
+	spoolLog         = log.Component("spool")
+	commandsLog      = log.Component("commands")
+	devicesLog       = log.Component("devices")
+	filesLog         = log.Component("files")
+)
+
+// devicehive-go library has its own logger
+const libraryLogComponent = "devicehive"
+
+// check logging configuration without applying it
+func checkLogging(config conf.Conf) error {
+	switch config.LoggingFormat {
+	case "logfmt", "json":
+	default:
+		return fmt.Errorf("unknown logging format %q", config.LoggingFormat)
+	}
+	if _, err := log.ParseLevel(config.LoggingLevel); err != nil {
+		return err
+	}
+	for component, level := range config.LoggingLevels {
+		if _, err := log.ParseLevel(level); err != nil {
+			return fmt.Errorf("%s: %s", component, err)
+		}
+	}
+	return nil
+}
+
+// apply logging format and levels
+func applyLogging(config conf.Conf) error {
+	if err := checkLogging(config); err != nil {
+		return err
+	}
+	log.SetFormat(config.LoggingFormat)
+	log.SetLevels(config.LoggingLevel, config.LoggingLevels)
+
+	level := config.LoggingLevel
+	if l, ok := config.LoggingLevels[libraryLogComponent]; ok {
+		level = l
+	}
+	dhlog.SetLevelByName(level)
+	return nil
+}
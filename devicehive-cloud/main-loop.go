@@ -2,17 +2,24 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 
 	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/dbusjson"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/filetransfer"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/history"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/pqueue"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/spool"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
 	"github.com/devicehive/devicehive-go/devicehive"
-	"github.com/devicehive/devicehive-go/devicehive/core"
-	"github.com/devicehive/devicehive-go/devicehive/log"
 
 	"github.com/godbus/dbus"
 	"github.com/godbus/dbus/introspect"
 	"github.com/godbus/dbus/prop"
 
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -21,61 +28,443 @@ const (
 	ComDevicehiveCloudIface = "com.devicehive.cloud"
 
 	waitTimeout = 30 * time.Second
+
+	// delay between attempts to send spooled notifications
+	spoolRetryTimeout = 10 * time.Second
+
+	// DeviceHive timestamp format
+	timestampLayout = "2006-01-02T15:04:05.000000"
 )
 
 // DBus wrapper object
 type DBusWrapper struct {
-	service devicehive.Service
-	device  *core.Device
+	conn *connection
+
+	bus     *dbus.Conn
+	queue   *pqueue.PriorityQueue
+	spool   *spool.Spool           // optional
+	files   *filetransfer.Receiver // optional
+	journal *history.Journal       // optional
+	props   *prop.Properties
+
+	sendDone     chan struct{} // closed when sendLoop is stopped
+	fileCommands chan func()   // handled by fileLoop
+	sending      *spool.Record // being sent by sendLoop, taken over on shutdown timeout
+	sendingMutex sync.Mutex
+
+	devices      map[string]dbus.ObjectPath // proxied devices
+	devicesMutex sync.Mutex
+
+	handlers      map[handlerKey]commandHandler
+	handlersMutex sync.RWMutex
+
+	pending *pendingCommands
+
+	configFile     string // empty if not provided
+	loadConfig     configLoader
+	reloadMutex    sync.Mutex // serializes reloads
+	configChanging bool       // remote change is being checked, guarded by reloadMutex
+
+	aggregator *aggregator
+	policies   *notificationPolicies
+	validator  *payloadValidator
+}
+
+// BatchNotification is a D-Bus representation of notification sent in batch
+type BatchNotification struct {
+	Device     string // empty for the gateway device
+	Name       string
+	Parameters string // JSON string
+	Priority   uint64
 }
 
 // send notification
-// priority is ignored
+// notification is queued and sent asynchronously,
+// the higher priority the sooner it is sent
 func (w *DBusWrapper) SendNotification(name, parameters string, priority uint64) *dbus.Error {
-	log.Infof("sending notification(name=%q, params=%q, priority=%d)", name, parameters, priority)
+	return w.sendNotification("", name, parameters, priority)
+}
+
+// send several notifications at once
+// each notification is (device, name, parameters, priority),
+// empty device means the gateway, parameters is a JSON string
+// the whole batch is rejected if any notification is invalid
+func (w *DBusWrapper) SendNotifications(notifications []BatchNotification) *dbus.Error {
+	notificationsLog.Infof("sending %d notifications", len(notifications))
+	dat := make([]interface{}, len(notifications))
+	for i, n := range notifications {
+		var err error
+		if dat[i], err = parseJSON(n.Parameters); err != nil {
+			notificationsLog.Warnf("failed to convert notification #%d parameters to JSON (error: %s)", i, err)
+			return newDHError(fmt.Sprintf("notification #%d: %s", i, err))
+		}
+		if err = w.validator.notification(n.Name, dat[i]); err != nil {
+			notificationsLog.Warnf("notification #%d is rejected (error: %s)", i, err)
+			return newDHError(fmt.Sprintf("notification #%d: %s", i, err))
+		}
+	}
+
+	for i, n := range notifications {
+		w.queueNotification(n.Device, n.Name, dat[i], n.Priority)
+	}
+	return nil // OK
+}
+
+// update command result
+func (w *DBusWrapper) UpdateCommand(id uint64, status, result string) *dbus.Error {
+	return w.updateCommand("", id, status, result)
+}
+
+// send notification with typed parameters
+func (w *DBusWrapper) SendNotificationV(name string, parameters map[string]dbus.Variant, priority uint64) *dbus.Error {
+	return w.sendNotificationV("", name, parameters, priority)
+}
+
+// update command with typed result
+func (w *DBusWrapper) UpdateCommandV(id uint64, status string, result dbus.Variant) *dbus.Error {
+	return w.updateCommandV("", id, status, result)
+}
+
+// send notification on behalf of the device
+func (w *DBusWrapper) sendNotification(deviceId, name, parameters string, priority uint64) *dbus.Error {
+	notificationsLog.Infof("sending notification(device=%q, name=%q, params=%q, priority=%d)", deviceId, name, parameters, priority)
 	dat, err := parseJSON(parameters)
 	if err != nil {
-		log.Warnf("failed to convert notification parameters to JSON (error: %s)", err)
+		notificationsLog.Warnf("failed to convert notification parameters to JSON (error: %s)", err)
+		return newDHError(err.Error())
+	}
+	if err = w.validator.notification(name, dat); err != nil {
+		notificationsLog.Warnf("notification is rejected (error: %s)", err)
 		return newDHError(err.Error())
 	}
 
-	notification := devicehive.NewNotification(name, dat)
-	err = w.service.InsertNotification(w.device, notification, waitTimeout)
+	w.queueNotification(deviceId, name, dat, priority)
+	return nil // OK
+}
+
+// send notification with typed parameters on behalf of the device
+func (w *DBusWrapper) sendNotificationV(deviceId, name string, parameters map[string]dbus.Variant, priority uint64) *dbus.Error {
+	notificationsLog.Infof("sending notification(device=%q, name=%q, params=%v, priority=%d)", deviceId, name, parameters, priority)
+	dat, err := dbusjson.ToJSON(parameters)
 	if err != nil {
-		log.Warnf("failed to send notification (error: %s)", err)
+		notificationsLog.Warnf("failed to convert notification parameters to JSON (error: %s)", err)
+		return newDHError(err.Error())
+	}
+	if err = w.validator.notification(name, dat); err != nil {
+		notificationsLog.Warnf("notification is rejected (error: %s)", err)
 		return newDHError(err.Error())
 	}
 
+	w.queueNotification(deviceId, name, dat, priority)
 	return nil // OK
 }
 
-// update command result
-func (w *DBusWrapper) UpdateCommand(id uint64, status, result string) *dbus.Error {
-	log.Infof("updating command(id:%d, status=%q, result:%q", id, status, result)
+// put notification to the outbound queue (or to the aggregation window)
+func (w *DBusWrapper) queueNotification(deviceId, name string, dat interface{}, priority uint64) {
+	if !w.acceptNotification(deviceId, name, dat) {
+		return // suppressed
+	}
+	if w.aggregator.add(deviceId, name, dat, priority) {
+		return // will be sent at the end of the window
+	}
+	w.enqueue(deviceId, name, dat, priority)
+}
+
+// put notification to the outbound queue
+func (w *DBusWrapper) enqueue(deviceId, name string, dat interface{}, priority uint64) {
+	msg := pqueue.Message{
+		"device":     deviceId,
+		"name":       name,
+		"parameters": dat,
+		"priority":   priority,
+		"timestamp":  time.Now(), // original time, kept when spooled
+	}
+	if seq := w.policies.queued(deviceId, name); seq != 0 {
+		msg["seq"] = seq
+	}
+	ttl := w.policies.ttl(name)
+	if ttl == 0 {
+		ttl = w.conn.Config().NotificationTTL
+	}
+	removed := w.queue.SendTTL(msg, priority, ttl)
+	reason := "outbound queue is full"
+	if w.queue.Closed() {
+		reason = "service is stopping"
+	}
+	for _, item := range removed {
+		w.notificationDropped(queuedRecord(item), reason)
+	}
+	w.updateQueueLength()
+}
+
+// report notification expired in the outbound queue
+func (w *DBusWrapper) notificationExpired(item pqueue.QueueItem) {
+	w.notificationDropped(queuedRecord(item), "notification is expired")
+	w.updateQueueLength()
+}
+
+// notification of the outbound queue item
+func queuedRecord(item pqueue.QueueItem) spool.Record {
+	deviceId, _ := item.Msg["device"].(string)
+	name, _ := item.Msg["name"].(string)
+	return spool.Record{
+		Device:     deviceId,
+		Name:       name,
+		Parameters: item.Msg["parameters"],
+		Priority:   item.Priority,
+		Timestamp:  item.Queued,
+	}
+}
+
+// get outbound queue options
+func queueOptions(config conf.Conf) (options pqueue.Options, err error) {
+	options.Aging = config.QueueAging
+	switch config.QueueEviction {
+	case "lowest":
+		options.Eviction = pqueue.EvictLowest
+	case "oldest":
+		options.Eviction = pqueue.EvictOldest
+	default:
+		err = fmt.Errorf("unknown queue eviction %q", config.QueueEviction)
+	}
+	return
+}
+
+// update command result of the device
+func (w *DBusWrapper) updateCommand(deviceId string, id uint64, status, result string) *dbus.Error {
+	clog := commandsLog.With("command", id)
+	clog.Infof("updating command(device=%q, id:%d, status=%q, result:%q", deviceId, id, status, result)
 	dat, err := parseJSON(result)
 	if err != nil {
-		log.Warnf("failed to convert command result to JSON (error: %s)", err)
+		clog.Warnf("failed to convert command result to JSON (error: %s)", err)
+		return newDHError(err.Error())
+	}
+	if err = w.validateCommandResult(id, dat); err != nil {
+		clog.Warnf("command result is rejected (error: %s)", err)
 		return newDHError(err.Error())
 	}
 
-	command := devicehive.NewCommandResult(id, status, dat)
-	err = w.service.UpdateCommand(w.device, command, waitTimeout)
+	err = w.postCommandResult(deviceId, id, status, dat)
 	if err != nil {
-		log.Warnf("failed to update command (error: %s)", err)
+		clog.Warnf("failed to update command (error: %s)", err)
 		return newDHError(err.Error())
 	}
 
 	return nil // OK
 }
 
+// update command with typed result of the device
+func (w *DBusWrapper) updateCommandV(deviceId string, id uint64, status string, result dbus.Variant) *dbus.Error {
+	clog := commandsLog.With("command", id)
+	clog.Infof("updating command(device=%q, id:%d, status=%q, result:%v", deviceId, id, status, result)
+	dat, err := dbusjson.ToJSON(result)
+	if err != nil {
+		clog.Warnf("failed to convert command result to JSON (error: %s)", err)
+		return newDHError(err.Error())
+	}
+	if err = w.validateCommandResult(id, dat); err != nil {
+		clog.Warnf("command result is rejected (error: %s)", err)
+		return newDHError(err.Error())
+	}
+
+	err = w.postCommandResult(deviceId, id, status, dat)
+	if err != nil {
+		clog.Warnf("failed to update command (error: %s)", err)
+		return newDHError(err.Error())
+	}
+
+	return nil // OK
+}
+
+// check command result against schema of the pending command
+// results of unknown (or already answered) commands are not checked
+func (w *DBusWrapper) validateCommandResult(id uint64, dat interface{}) error {
+	name, ok := w.pending.name(id)
+	if !ok || dat == nil {
+		return nil
+	}
+	return w.validator.commandResult(name, dat)
+}
+
+// send queued notifications one by one until the queue is closed
+func (w *DBusWrapper) sendLoop() {
+	defer close(w.sendDone)
+	for msg := range w.queue.Out() {
+		deviceId, _ := msg["device"].(string)
+		name, _ := msg["name"].(string)
+		priority, _ := msg["priority"].(uint64)
+		timestamp, _ := msg["timestamp"].(time.Time)
+		if seq, _ := msg["seq"].(uint64); w.supersededNotification(deviceId, name, msg["parameters"], seq) {
+			w.updateQueueLength()
+			continue
+		}
+		record := spool.Record{
+			Device:     deviceId,
+			Name:       name,
+			Parameters: msg["parameters"],
+			Priority:   priority,
+			Timestamp:  timestamp,
+		}
+
+		if w.spool != nil && w.spool.Len() != 0 {
+			// keep the order, spooled notifications go first
+			w.store(record)
+		} else if err := w.send(record); err == errTakenOver {
+			// spooled or dropped by shutdown
+		} else if err != nil {
+			notificationsLog.Warnf("failed to send notification (error: %s)", err)
+			if w.spool != nil && err != errUnknownDevice {
+				w.store(record)
+			} else {
+				w.notificationDropped(record, err.Error())
+			}
+		}
+		w.updateQueueLength()
+	}
+}
+
+// the record is taken over by shutdown
+var errTakenOver = errors.New("notification is taken over")
+
+// send the notification, shutdown may take it over while it is in progress
+func (w *DBusWrapper) send(record spool.Record) error {
+	w.sendingMutex.Lock()
+	w.sending = &record
+	w.sendingMutex.Unlock()
+
+	err := w.insertNotification(record)
+
+	w.sendingMutex.Lock()
+	defer w.sendingMutex.Unlock()
+	if w.sending == nil {
+		return errTakenOver
+	}
+	w.sending = nil
+	return err
+}
+
+// take the notification being sent, nil if nothing is in progress
+func (w *DBusWrapper) takeSending() *spool.Record {
+	w.sendingMutex.Lock()
+	defer w.sendingMutex.Unlock()
+	record := w.sending
+	w.sending = nil
+	return record
+}
+
+// replay spooled notifications once the cloud is reachable again
+// the record is removed after it is sent, so sendLoop keeps
+// spooling new notifications behind it while it is being sent
+func (w *DBusWrapper) forwardLoop() {
+	for {
+		sent := false
+
+		record, expired, err := w.spool.Peek()
+		w.spoolDropped(expired, "spooled notification is expired")
+		if err != nil {
+			spoolLog.Warnf("failed to read spooled notification (error: %s)", err)
+		} else if record != nil {
+			err = w.insertNotification(*record)
+			if err == errUnknownDevice {
+				// device is unregistered, no way to send it
+				w.notificationDropped(*record, err.Error())
+				err = nil
+			}
+			if err != nil {
+				spoolLog.Debugf("failed to send spooled notification (error: %s)", err)
+			} else if err = w.spool.Remove(record); err != nil {
+				spoolLog.Warnf("failed to remove spooled notification (error: %s)", err)
+			} else {
+				sent = true
+			}
+		}
+		w.updateQueueLength()
+
+		if !sent {
+			time.Sleep(spoolRetryTimeout)
+		}
+	}
+}
+
+// send notification to the cloud
+func (w *DBusWrapper) insertNotification(record spool.Record) error {
+	service, device, err := w.conn.Service(record.Device)
+	if err != nil {
+		return err
+	}
+
+	notification := devicehive.NewNotification(record.Name, record.Parameters)
+	notification.Timestamp = record.Timestamp.UTC().Format(timestampLayout)
+	entry := history.Entry{
+		Kind:       history.KindNotification,
+		Device:     record.Device,
+		Name:       record.Name,
+		Parameters: record.Parameters,
+		Status:     history.StatusSent,
+	}
+	if err = service.InsertNotification(device, notification, waitTimeout); err != nil {
+		notificationsFailed.WithLabelValues(metricName(record.Name)).Inc()
+		entry.Status, entry.Reason = history.StatusFailed, err.Error()
+		w.record(entry)
+		return err
+	}
+
+	w.conn.touch()
+	notificationsSent.WithLabelValues(metricName(record.Name)).Inc()
+	w.record(entry)
+	return nil
+}
+
+// store notification in the spool
+func (w *DBusWrapper) store(record spool.Record) {
+	spoolLog.Debugf("spooling notification(name=%q, priority=%d)", record.Name, record.Priority)
+	removed, err := w.spool.Append(record)
+	if err != nil {
+		spoolLog.Warnf("failed to spool notification (error: %s)", err)
+		w.notificationDropped(record, err.Error())
+	}
+	w.spoolDropped(removed, "spool is full")
+}
+
+// report notifications removed from the spool
+func (w *DBusWrapper) spoolDropped(records []spool.Record, reason string) {
+	for _, r := range records {
+		w.notificationDropped(r, reason)
+	}
+}
+
+// report dropped notification back to D-Bus clients
+func (w *DBusWrapper) notificationDropped(record spool.Record, reason string) {
+	params := ""
+	if record.Parameters != nil {
+		if buf, err := json.Marshal(record.Parameters); err == nil {
+			params = string(buf)
+		}
+	}
+
+	notificationsDropped.WithLabelValues(metricName(record.Name)).Inc()
+	w.record(history.Entry{
+		Kind:       history.KindNotification,
+		Device:     record.Device,
+		Name:       record.Name,
+		Parameters: record.Parameters,
+		Status:     history.StatusDropped,
+		Reason:     reason,
+	})
+	notificationsLog.Warnf("notification(name=%q, priority=%d) dropped (reason: %s)", record.Name, record.Priority, reason)
+	w.bus.Emit(ComDevicehiveCloudPath, ComDevicehiveCloudIface+".NotificationDropped", record.Name, params, record.Priority, reason)
+}
+
 // export main + introspectable DBus objects
-func exportDBusObject(bus *dbus.Conn, w *DBusWrapper) {
+func exportDBusObject(bus *dbus.Conn, w *DBusWrapper, config conf.Conf) {
 	bus.Export(w, ComDevicehiveCloudPath, ComDevicehiveCloudIface)
+	log.Export(bus, ComDevicehiveCloudPath)
 
 	// main service interface
 	serviceInterface := introspect.Interface{
-		Name:    ComDevicehiveCloudIface,
-		Methods: introspect.Methods(w),
+		Name:       ComDevicehiveCloudIface,
+		Methods:    introspect.Methods(w),
+		Properties: w.exportProperties(bus, config),
 		Signals: []introspect.Signal{
 			{
 				Name: "CommandReceived",
@@ -85,6 +474,38 @@ func exportDBusObject(bus *dbus.Conn, w *DBusWrapper) {
 					{"parameters", "s", "out"}, // JSON string
 				},
 			},
+			{
+				Name: "CommandReceivedV",
+				Args: []introspect.Arg{
+					{"id", "t", "out"},
+					{"name", "s", "out"},
+					{"parameters", "a{sv}", "out"},
+				},
+			},
+			{
+				Name: "NotificationDropped",
+				Args: []introspect.Arg{
+					{"name", "s", "out"},
+					{"parameters", "s", "out"}, // JSON string
+					{"priority", "t", "out"},
+					{"reason", "s", "out"},
+				},
+			},
+			{
+				Name: "FileReceived",
+				Args: []introspect.Arg{
+					{"name", "s", "out"}, // as sent by the cloud
+					{"path", "s", "out"},
+					{"size", "t", "out"},
+				},
+			},
+			{
+				Name: "ConnectionStateChanged",
+				Args: []introspect.Arg{
+					{"state", "s", "out"}, // disconnected, connecting or connected
+					{"lastError", "s", "out"},
+				},
+			},
 		},
 	}
 
@@ -94,7 +515,11 @@ func exportDBusObject(bus *dbus.Conn, w *DBusWrapper) {
 		Interfaces: []introspect.Interface{
 			introspect.IntrospectData,
 			prop.IntrospectData,
+			log.Introspection(),
 			serviceInterface},
+		Children: []introspect.Node{
+			{Name: strings.TrimPrefix(ComDevicehiveCloudDevicesPath, ComDevicehiveCloudPath+"/")},
+		},
 	}
 	n_obj := introspect.NewIntrospectable(n)
 	log.Tracef("%q introspectable: %s", ComDevicehiveCloudPath, n_obj)
@@ -109,57 +534,136 @@ func exportDBusObject(bus *dbus.Conn, w *DBusWrapper) {
 	root_obj := introspect.NewIntrospectable(root)
 	log.Tracef("%q introspectable: %s", "/", root_obj)
 	bus.Export(root_obj, "/", "org.freedesktop.DBus.Introspectable")
+
+	// proxied devices node
+	w.exportDevicesNode()
 }
 
 // main loop
-func mainLoop(bus *dbus.Conn, service devicehive.Service, config conf.Conf) {
-	// getting server info
-	info, err := service.GetServerInfo(waitTimeout)
+func mainLoop(bus *dbus.Conn, config conf.Conf, configFile string, newService serviceFactory, loadConfig configLoader) {
+	options, err := queueOptions(config)
 	if err != nil {
-		log.Warnf("Cannot get service info (error: %s)", err)
+		log.Warnf("Cannot create notification queue (error: %s)", err)
 		return
 	}
 
-	// registering device
-	device := devicehive.NewDevice(config.DeviceID, config.DeviceName,
-		devicehive.NewDeviceClass("go-gateway-class", "0.1"))
-	device.Key = config.DeviceKey
-	if len(config.NetworkName)!=0 || len(config.NetworkKey)!=0 {
-		device.Network = devicehive.NewNetwork(config.NetworkName, config.NetworkKey)
-		device.Network.Description = config.NetworkDesc
-	}
-	err = service.RegisterDevice(device, waitTimeout)
+	conn := newConnection(config, newService)
+	wrapper := DBusWrapper{conn: conn, bus: bus, configFile: configFile, loadConfig: loadConfig}
+	options.Expired = wrapper.notificationExpired
+	wrapper.queue, err = pqueue.NewPriorityQueueOptions(config.SendNotificatonQueueCapacity, make(chan pqueue.Message), options)
 	if err != nil {
-		log.Warnf("Cannot register device (error: %s)", err)
+		log.Warnf("Cannot create notification queue (error: %s)", err)
 		return
 	}
-
-	// start polling commands
-	listener, err := service.SubscribeCommands(device, info.Timestamp, waitTimeout)
+	wrapper.sendDone = make(chan struct{})
+	wrapper.fileCommands = make(chan func(), fileCommandsCapacity)
+	wrapper.devices = make(map[string]dbus.ObjectPath)
+	wrapper.handlers = make(map[handlerKey]commandHandler)
+	wrapper.pending = newPendingCommands()
+	wrapper.policies = newNotificationPolicies(config.Policies)
+	wrapper.validator, err = newPayloadValidator(config)
 	if err != nil {
-		log.Warnf("Cannot subscribe commands (error: %s)")
+		log.Warnf("Cannot load JSON schemas (error: %s)", err)
 		return
 	}
+	wrapper.aggregator = newAggregator(config.Aggregation, wrapper.enqueue)
+	if len(config.SpoolDir) != 0 {
+		wrapper.spool, err = spool.Open(config.SpoolDir, int64(config.SpoolMaxSize), config.SpoolMaxAge)
+		if err != nil {
+			log.Warnf("Cannot open notification spool %q (error: %s)", config.SpoolDir, err)
+			return
+		}
+		log.Infof("%d spooled notifications found in %q", wrapper.spool.Len(), config.SpoolDir)
+	}
+	if len(config.FileDir) != 0 {
+		wrapper.files, err = filetransfer.New(config.FileDir, int64(config.FileMaxSize))
+		if err != nil {
+			log.Warnf("Cannot open file transfer directory %q (error: %s)", config.FileDir, err)
+			return
+		}
+	}
+	if len(config.HistoryDir) != 0 {
+		wrapper.journal, err = history.Open(config.HistoryDir, int64(config.HistoryMaxSize), config.HistoryFiles)
+		if err != nil {
+			log.Warnf("Cannot open history journal %q (error: %s)", config.HistoryDir, err)
+			return
+		}
+	}
+	setMetricNames(config.MetricsNames)
+	if len(config.MetricsAddr) != 0 {
+		if err = wrapper.startMetrics(config.MetricsAddr); err != nil {
+			// metrics are optional, keep working without the endpoint
+			log.Warnf("Cannot start metrics endpoint %q (error: %s)", config.MetricsAddr, err)
+		}
+	}
 
-	wrapper := DBusWrapper{service: service, device: device}
-	exportDBusObject(bus, &wrapper)
+	// D-Bus object stays exported while reconnecting,
+	// properties are created before they are updated by loops below
+	exportDBusObject(bus, &wrapper, config)
+	conn.onChange = wrapper.onConnectionChanged
 
-	for {
-		select {
-		case cmd := <-listener.C:
-			params := ""
-			if cmd.Parameters != nil {
-				buf, err := json.Marshal(cmd.Parameters)
-				if err != nil {
-					log.Warnf("Cannot generate JSON from parameters of command %+v (error: %s)", cmd, err)
-					continue
-				}
-				params = string(buf)
+	go wrapper.sendLoop()
+	if wrapper.spool != nil {
+		go wrapper.forwardLoop()
+	}
+	go wrapper.fileLoop()
+	go wrapper.expireLoop()
+	go wrapper.watchHandlerOwners()
+	go wrapper.reloadOnSignal()
+	go wrapper.shutdownOnSignal()
+
+	go conn.run()
+
+	for cmd := range conn.Commands() {
+		clog := commandsLog.With("command", cmd.Id)
+		path := wrapper.devicePath(cmd.DeviceId)
+		if len(path) == 0 {
+			clog.Warnf("Skipping command %+v of unregistered device %q", cmd.Command, cmd.DeviceId)
+			continue
+		}
+
+		params := ""
+		if cmd.Parameters != nil {
+			buf, err := json.Marshal(cmd.Parameters)
+			if err != nil {
+				clog.Warnf("Cannot generate JSON from parameters of command %+v (error: %s)", cmd.Command, err)
+				continue
 			}
-			log.Infof("COMMAND %s -> %s(%v)", config.URL, cmd.Name, params)
-			bus.Emit(ComDevicehiveCloudPath, ComDevicehiveCloudIface+".CommandReceived", cmd.Id, cmd.Name, params)
+			params = string(buf)
 		}
+		// without secrets and file data
+		logged, loggedParams := commandParameters(cmd), params
+		if buf, err := json.Marshal(logged); err == nil && logged != nil {
+			loggedParams = string(buf)
+		}
+		clog.Infof("COMMAND %s -> %s(%v) for %q", conn.Config().URL, cmd.Name, loggedParams, path)
+		wrapper.pending.add(cmd)
+		wrapper.record(history.Entry{
+			Kind:       history.KindCommand,
+			Device:     cmd.DeviceId,
+			Id:         cmd.Id,
+			Name:       cmd.Name,
+			Parameters: logged,
+		})
+		commandsReceived.WithLabelValues(metricName(cmd.Name)).Inc()
+		switch {
+		case wrapper.gatewayCommand(cmd):
+			continue // answered by the daemon
+		case wrapper.dispatchCommand(cmd, params):
+			continue // handled by registered handler
+		}
+		bus.Emit(path, ComDevicehiveCloudIface+".CommandReceived", cmd.Id, cmd.Name, params)
 
-		//time.Sleep(5 * time.Second)
+		// the same command with typed parameters
+		if paramsV, err := dbusjson.ObjectFromJSON(cmd.Parameters); err != nil {
+			clog.Warnf("Cannot convert parameters of command %+v to D-Bus (error: %s)", cmd.Command, err)
+		} else {
+			bus.Emit(path, ComDevicehiveCloudIface+".CommandReceivedV", cmd.Id, cmd.Name, paramsV)
+		}
+
+		// no signal client is expected to answer
+		if *conn.Config().FailUnhandledCommands {
+			wrapper.failUnhandledCommand(cmd)
+		}
 	}
 }
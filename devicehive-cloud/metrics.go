@@ -0,0 +1,146 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "devicehive_cloud"
+
+	// label of notifications and commands not in the allow-list
+	otherMetricName = "other"
+)
+
+// notification and command names used as metric labels,
+// the allow-list keeps the number of label values bounded
+var metricNames = struct {
+	sync.RWMutex
+	names map[string]bool
+}{}
+
+// gateway commands are always allowed
+var gatewayMetricNames = make(map[string]bool)
+
+// metrics are collected even if the endpoint is disabled
+var (
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_sent_total",
+		Help:      "Notifications sent to the cloud.",
+	}, []string{"name"})
+
+	notificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_failed_total",
+		Help:      "Failed attempts to send notifications (including spooled ones).",
+	}, []string{"name"})
+
+	notificationsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notifications_dropped_total",
+		Help:      "Notifications dropped or suppressed by policies.",
+	}, []string{"name"})
+
+	commandsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_received_total",
+		Help:      "Commands received from the cloud.",
+	}, []string{"name"})
+
+	commandsAnswered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "commands_answered_total",
+		Help:      "Commands answered (including timed out ones).",
+	}, []string{"name"})
+
+	commandLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "command_latency_seconds",
+		Help:      "Time from command receipt to its answer.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}, []string{"name"})
+
+	reconnects = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconnects_total",
+		Help:      "Attempts to connect to the cloud again.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(notificationsSent, notificationsFailed, notificationsDropped,
+		commandsReceived, commandsAnswered, commandLatency, reconnects)
+
+	for name := range gatewayCommands {
+		gatewayMetricNames[name] = true
+	}
+}
+
+// set the allow-list
+func setMetricNames(names []string) {
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+
+	metricNames.Lock()
+	defer metricNames.Unlock()
+	metricNames.names = m
+}
+
+// get metric label of the notification or command name
+func metricName(name string) string {
+	metricNames.RLock()
+	defer metricNames.RUnlock()
+	if metricNames.names[name] || gatewayMetricNames[name] {
+		return name
+	}
+	return otherMetricName
+}
+
+// serve Prometheus metrics on the address in background
+func (w *DBusWrapper) startMetrics(addr string) error {
+	prometheus.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "outbound_queue_length",
+			Help:      "Notifications waiting to be sent (including spooled ones).",
+		}, func() float64 { return float64(w.queueLength()) }),
+
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "seconds_since_last_contact",
+			Help:      "Time since the last successful server contact (or since start).",
+		}, func() float64 {
+			last := w.conn.LastContact()
+			if last.IsZero() {
+				last = started
+			}
+			return time.Since(last).Seconds()
+		}),
+	)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Warnf("metrics endpoint is stopped (error: %s)", err)
+		}
+	}()
+
+	log.Infof("metrics are served at http://%s/metrics", l.Addr())
+	return nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestMetricName(t *testing.T) {
+	defer setMetricNames(nil)
+
+	setMetricNames([]string{"temperature", "scan/start"})
+	tests := map[string]string{
+		"temperature":  "temperature",
+		"scan/start":   "scan/start",
+		"gateway/ping": "gateway/ping",
+		"humidity":     otherMetricName,
+		"":             otherMetricName,
+	}
+	for name, expected := range tests {
+		if res := metricName(name); res != expected {
+			t.Errorf("%q: label %q, expected: %q", name, res, expected)
+		}
+	}
+
+	setMetricNames(nil)
+	if res := metricName("temperature"); res != otherMetricName {
+		t.Errorf("removed name is labeled %q", res)
+	}
+}
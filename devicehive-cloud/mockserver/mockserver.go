@@ -0,0 +1,412 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/devicehive/devicehive-go/devicehive/log"
+)
+
+const (
+	// DeviceHive timestamp format
+	timestampLayout = "2006-01-02T15:04:05.000000"
+
+	apiVersion = "2.0.0"
+
+	// default and maximum long-poll timeouts
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 60 * time.Second
+)
+
+// Notification received from a device
+type Notification struct {
+	Id         uint64      `json:"id"`
+	Name       string      `json:"notification"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Timestamp  string      `json:"timestamp"`
+}
+
+// Command sent to a device
+type Command struct {
+	Id         uint64      `json:"id"`
+	Name       string      `json:"command"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Timestamp  string      `json:"timestamp"`
+	Lifetime   uint64      `json:"lifetime,omitempty"`
+	Status     string      `json:"status,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// registered device
+type device struct {
+	info          map[string]interface{}
+	notifications []*Notification
+	commands      []*Command
+	polled        chan struct{} // closed on the first command poll
+}
+
+// Server is a minimal in-memory DeviceHive REST server.
+// It accepts device registrations, notifications and command updates
+// and provides long-polling of commands. Authentication is not checked.
+type Server struct {
+	mu            sync.Mutex
+	devices       map[string]*device
+	lastId        uint64
+	lastTimestamp time.Time
+	changed       chan struct{} // closed when new command is inserted
+}
+
+// New creates an empty server
+func New() *Server {
+	return &Server{
+		devices: make(map[string]*device),
+		changed: make(chan struct{}),
+	}
+}
+
+// Start serves REST API on the address in background
+// returns the base URL of the REST API
+func (s *Server) Start(addr string) (string, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+
+	go func() {
+		if err := http.Serve(l, s); err != nil {
+			log.Warnf("mock server is stopped (error: %s)", err)
+		}
+	}()
+
+	url := "http://" + l.Addr().String()
+	log.Infof("mock DeviceHive server is started at %q", url)
+	return url, nil
+}
+
+// InsertCommand sends a new command to the device
+func (s *Server) InsertCommand(deviceId, name string, parameters interface{}) Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cmd := &Command{
+		Id:         s.nextId(),
+		Name:       name,
+		Parameters: parameters,
+		Timestamp:  s.nextTimestamp(),
+	}
+	d := s.device(deviceId)
+	d.commands = append(d.commands, cmd)
+
+	// wake up all pollers
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	log.Infof("mock server: command %q is inserted for %q", name, deviceId)
+	return *cmd
+}
+
+// Polled returns a channel closed once commands of the device are polled,
+// commands inserted after that are delivered to the poller
+func (s *Server) Polled(deviceId string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.device(deviceId).polled
+}
+
+// Command returns the device command (with updated status and result)
+func (s *Server) Command(deviceId string, id uint64) (Command, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d, ok := s.devices[deviceId]; ok {
+		for _, cmd := range d.commands {
+			if cmd.Id == id {
+				return *cmd, true
+			}
+		}
+	}
+	return Command{}, false
+}
+
+// Notifications returns all notifications received from the device
+func (s *Server) Notifications(deviceId string) []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res []Notification
+	if d, ok := s.devices[deviceId]; ok {
+		for _, n := range d.notifications {
+			res = append(res, *n)
+		}
+	}
+	return res
+}
+
+// ServeHTTP implements DeviceHive REST API subset:
+//
+//	GET  /info
+//	GET  /device/{id}
+//	PUT  /device/{id}
+//	GET  /device/{id}/notification
+//	POST /device/{id}/notification
+//	POST /device/{id}/command
+//	GET  /device/{id}/command/poll
+//	GET  /device/{id}/command/{commandId}
+//	PUT  /device/{id}/command/{commandId}
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("mock server: %s %s", r.Method, r.URL)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	switch {
+	case len(parts) == 1 && parts[0] == "info" && r.Method == "GET":
+		s.getInfo(w)
+	case len(parts) < 2 || parts[0] != "device":
+		http.NotFound(w, r)
+	case len(parts) == 2 && r.Method == "GET":
+		s.getDevice(w, parts[1])
+	case len(parts) == 2 && r.Method == "PUT":
+		s.putDevice(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "notification" && r.Method == "GET":
+		writeJSON(w, http.StatusOK, s.Notifications(parts[1]))
+	case len(parts) == 3 && parts[2] == "notification" && r.Method == "POST":
+		s.postNotification(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "command" && r.Method == "POST":
+		s.postCommand(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "command" && parts[3] == "poll" && r.Method == "GET":
+		s.pollCommands(w, r, parts[1])
+	case len(parts) == 4 && parts[2] == "command" && r.Method == "GET":
+		s.getCommand(w, parts[1], parts[3])
+	case len(parts) == 4 && parts[2] == "command" && r.Method == "PUT":
+		s.putCommand(w, r, parts[1], parts[3])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// GET /info
+func (s *Server) getInfo(w http.ResponseWriter) {
+	s.mu.Lock()
+	timestamp := s.nextTimestamp()
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"apiVersion":      apiVersion,
+		"serverTimestamp": timestamp,
+	})
+}
+
+// GET /device/{id}
+func (s *Server) getDevice(w http.ResponseWriter, deviceId string) {
+	s.mu.Lock()
+	d, ok := s.devices[deviceId]
+	s.mu.Unlock()
+	if !ok || d.info == nil {
+		writeError(w, http.StatusNotFound, "device not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, d.info)
+}
+
+// PUT /device/{id}
+func (s *Server) putDevice(w http.ResponseWriter, r *http.Request, deviceId string) {
+	var info map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info["id"] = deviceId
+
+	s.mu.Lock()
+	s.device(deviceId).info = info
+	s.mu.Unlock()
+
+	log.Infof("mock server: device %q is registered", deviceId)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /device/{id}/notification
+func (s *Server) postNotification(w http.ResponseWriter, r *http.Request, deviceId string) {
+	var n Notification
+	if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	n.Id = s.nextId()
+	if len(n.Timestamp) == 0 {
+		n.Timestamp = s.nextTimestamp()
+	}
+	d := s.device(deviceId)
+	d.notifications = append(d.notifications, &n)
+	s.mu.Unlock()
+
+	log.Infof("mock server: notification %q is received from %q", n.Name, deviceId)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":        n.Id,
+		"timestamp": n.Timestamp,
+	})
+}
+
+// POST /device/{id}/command
+func (s *Server) postCommand(w http.ResponseWriter, r *http.Request, deviceId string) {
+	var cmd Command
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(cmd.Name) == 0 {
+		writeError(w, http.StatusBadRequest, "command name is required")
+		return
+	}
+
+	res := s.InsertCommand(deviceId, cmd.Name, cmd.Parameters)
+	writeJSON(w, http.StatusCreated, map[string]interface{}{
+		"id":        res.Id,
+		"timestamp": res.Timestamp,
+	})
+}
+
+// GET /device/{id}/command/poll?timestamp=...&waitTimeout=...
+func (s *Server) pollCommands(w http.ResponseWriter, r *http.Request, deviceId string) {
+	timestamp := r.URL.Query().Get("timestamp")
+	timeout := defaultWaitTimeout
+	if v := r.URL.Query().Get("waitTimeout"); len(v) != 0 {
+		if sec, err := strconv.ParseUint(v, 10, 32); err == nil {
+			timeout = time.Duration(sec) * time.Second
+		}
+	}
+	if timeout > maxWaitTimeout {
+		timeout = maxWaitTimeout
+	}
+
+	s.mu.Lock()
+	if len(timestamp) == 0 {
+		timestamp = s.nextTimestamp()
+	}
+	d := s.device(deviceId)
+	select {
+	case <-d.polled:
+	default:
+		close(d.polled)
+	}
+	s.mu.Unlock()
+
+	deadline := time.After(timeout)
+	for {
+		s.mu.Lock()
+		var res []*Command
+		if d, ok := s.devices[deviceId]; ok {
+			for _, cmd := range d.commands {
+				if cmd.Timestamp > timestamp {
+					res = append(res, cmd)
+				}
+			}
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		if len(res) != 0 {
+			writeJSON(w, http.StatusOK, res)
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-deadline:
+			writeJSON(w, http.StatusOK, []*Command{})
+			return
+		}
+	}
+}
+
+// GET /device/{id}/command/{commandId}
+func (s *Server) getCommand(w http.ResponseWriter, deviceId, commandId string) {
+	id, err := strconv.ParseUint(commandId, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cmd, ok := s.Command(deviceId, id)
+	if !ok {
+		writeError(w, http.StatusNotFound, "command not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, cmd)
+}
+
+// PUT /device/{id}/command/{commandId}
+func (s *Server) putCommand(w http.ResponseWriter, r *http.Request, deviceId, commandId string) {
+	id, err := strconv.ParseUint(commandId, 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var update Command
+	if err = json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[deviceId]; ok {
+		for _, cmd := range d.commands {
+			if cmd.Id == id {
+				cmd.Status = update.Status
+				cmd.Result = update.Result
+				log.Infof("mock server: command %d is updated (status: %q)", id, cmd.Status)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+	}
+	writeError(w, http.StatusNotFound, "command not found")
+}
+
+// get or create device, should be called under lock
+func (s *Server) device(deviceId string) *device {
+	d, ok := s.devices[deviceId]
+	if !ok {
+		d = &device{polled: make(chan struct{})}
+		s.devices[deviceId] = d
+	}
+	return d
+}
+
+// generate unique identifier, should be called under lock
+func (s *Server) nextId() uint64 {
+	s.lastId++
+	return s.lastId
+}
+
+// generate strictly increasing timestamp, should be called under lock
+func (s *Server) nextTimestamp() string {
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	if !now.After(s.lastTimestamp) {
+		now = s.lastTimestamp.Add(time.Microsecond)
+	}
+	s.lastTimestamp = now
+	return now.Format(timestampLayout)
+}
+
+// write JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// write JSON error
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error":   status,
+		"message": message,
+	})
+}
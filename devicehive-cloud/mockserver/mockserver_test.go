@@ -0,0 +1,126 @@
+package mockserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// send request and decode JSON response (if any)
+func call(t *testing.T, method, url string, body, res interface{}) int {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if res != nil {
+		if err = json.NewDecoder(resp.Body).Decode(res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// get server timestamp
+func serverTimestamp(t *testing.T, url string) string {
+	var info struct {
+		Timestamp string `json:"serverTimestamp"`
+	}
+	call(t, "GET", url+"/info", nil, &info)
+	return info.Timestamp
+}
+
+func TestScenarioWaitsForPoll(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.RunScenario([]Step{{Command: "scan/start"}}, "gw")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("scenario is finished before the first poll")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the device is subscribed from the current timestamp
+	var commands []Command
+	url := ts.URL + "/device/gw/command/poll?waitTimeout=5&timestamp=" + serverTimestamp(t, ts.URL)
+	call(t, "GET", url, nil, &commands)
+	if len(commands) != 1 || commands[0].Name != "scan/start" {
+		t.Errorf("unexpected commands %+v", commands)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Error("scenario is not finished")
+	}
+}
+
+func TestCommandUpdate(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var created Command
+	status := call(t, "POST", ts.URL+"/device/gw/command", map[string]interface{}{"command": "ping"}, &created)
+	if status != http.StatusCreated {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	update := map[string]interface{}{"status": "success", "result": "pong"}
+	status = call(t, "PUT", ts.URL+"/device/gw/command/"+strconv.FormatUint(created.Id, 10), update, nil)
+	if status != http.StatusNoContent {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	cmd, ok := s.Command("gw", created.Id)
+	if !ok || cmd.Status != "success" || cmd.Result != "pong" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+
+	status = call(t, "PUT", ts.URL+"/device/gw/command/42", update, nil)
+	if status != http.StatusNotFound {
+		t.Errorf("unexpected status %d of unknown command", status)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	s := New()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	n := map[string]interface{}{"notification": "temperature", "parameters": map[string]interface{}{"value": 21.5}}
+	if status := call(t, "POST", ts.URL+"/device/gw/notification", n, nil); status != http.StatusCreated {
+		t.Fatalf("unexpected status %d", status)
+	}
+
+	var res []Notification
+	call(t, "GET", ts.URL+"/device/gw/notification", nil, &res)
+	if len(res) != 1 || res[0].Name != "temperature" || len(res[0].Timestamp) == 0 {
+		t.Errorf("unexpected notifications %+v", res)
+	}
+}
@@ -0,0 +1,91 @@
+package mockserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/devicehive/devicehive-go/devicehive/log"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Step is a scenario command, sent after delay (relative to the previous step)
+type Step struct {
+	Delay      time.Duration `yaml:"delay,omitempty"`
+	Device     string        `yaml:"device,omitempty"` // default device if empty
+	Command    string        `yaml:"command"`
+	Parameters interface{}   `yaml:"parameters,omitempty"`
+}
+
+// LoadScenario reads scenario steps from YAML file, ex:
+//   - command: scan/start
+//   - delay: 10s
+//     command: connect
+//     parameters: {mac: b4994c6433be}
+func LoadScenario(filepath string) (steps []Step, err error) {
+	buf, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return
+	}
+	if err = yaml.Unmarshal(buf, &steps); err != nil {
+		return
+	}
+
+	for i := range steps {
+		if len(steps[i].Command) == 0 {
+			return nil, fmt.Errorf("step #%d: command is required", i+1)
+		}
+		steps[i].Parameters = fromYAML(steps[i].Parameters)
+	}
+	return
+}
+
+// NeedsDefaultDevice checks some steps have no device specified
+func NeedsDefaultDevice(steps []Step) bool {
+	for _, step := range steps {
+		if len(step.Device) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RunScenario inserts scenario commands one by one,
+// each command waits until commands of its device are polled
+func (s *Server) RunScenario(steps []Step, defaultDevice string) {
+	for _, step := range steps {
+		time.Sleep(step.Delay)
+
+		deviceId := step.Device
+		if len(deviceId) == 0 {
+			deviceId = defaultDevice
+		}
+		polled := s.Polled(deviceId)
+		select {
+		case <-polled:
+		default:
+			log.Infof("mock server: waiting for %q to poll commands", deviceId)
+			<-polled
+		}
+		s.InsertCommand(deviceId, step.Command, step.Parameters)
+	}
+	log.Infof("mock server: scenario is finished")
+}
+
+// convert YAML maps to JSON compatible maps
+func fromYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, v := range t {
+			m[fmt.Sprint(k)] = fromYAML(v)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = fromYAML(t[i])
+		}
+	}
+	return v
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	// status of expired commands
+	commandStatusTimeout = "timeout"
+
+	// how often pending commands are checked
+	pendingCheckInterval = 1 * time.Second
+
+	// failed timeout updates are retried with exponential backoff,
+	// the command is forgotten after maxExpireRetries attempts
+	minExpireRetryDelay = 5 * time.Second
+	maxExpireRetryDelay = 5 * time.Minute
+	maxExpireRetries    = 10
+)
+
+// delivered but not yet answered command
+type pendingCommand struct {
+	deviceId string
+	id       uint64
+	name     string
+	received time.Time
+
+	retries int       // failed timeout updates
+	retryAt time.Time // next timeout update attempt
+}
+
+// PendingCommand is a D-Bus representation of pending command
+type PendingCommand struct {
+	Id     uint64
+	Name   string
+	Device string // empty for the gateway device
+	Age    uint64 // in milliseconds
+}
+
+// commands delivered to D-Bus clients
+type pendingCommands struct {
+	sync.Mutex
+	commands map[uint64]*pendingCommand
+}
+
+// create new pending commands tracker
+func newPendingCommands() *pendingCommands {
+	return &pendingCommands{commands: make(map[uint64]*pendingCommand)}
+}
+
+// track delivered command
+func (p *pendingCommands) add(cmd deviceCommand) {
+	p.Lock()
+	defer p.Unlock()
+	p.commands[cmd.Id] = &pendingCommand{
+		deviceId: cmd.DeviceId,
+		id:       cmd.Id,
+		name:     cmd.Name,
+		received: time.Now(),
+	}
+}
+
+// stop tracking answered command, returns the removed command
+func (p *pendingCommands) remove(id uint64) (pendingCommand, bool) {
+	p.Lock()
+	defer p.Unlock()
+	c, ok := p.commands[id]
+	if !ok {
+		return pendingCommand{}, false
+	}
+	delete(p.commands, id)
+	return *c, true
+}
+
+// get name of the pending command
+func (p *pendingCommands) name(id uint64) (string, bool) {
+	p.Lock()
+	defer p.Unlock()
+	if c, ok := p.commands[id]; ok {
+		return c.name, true
+	}
+	return "", false
+}
+
+// get commands older than the deadline, postponed ones are skipped
+func (p *pendingCommands) expired(deadline, now time.Time) (res []pendingCommand) {
+	p.Lock()
+	defer p.Unlock()
+	for _, c := range p.commands {
+		if c.received.Before(deadline) && !now.Before(c.retryAt) {
+			res = append(res, *c)
+		}
+	}
+	return
+}
+
+// postpone the next timeout update of the command, returns the delay
+func (p *pendingCommands) postpone(id uint64, now time.Time) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	c, ok := p.commands[id]
+	if !ok {
+		return 0
+	}
+
+	delay := minExpireRetryDelay
+	for i := 0; i < c.retries && delay < maxExpireRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxExpireRetryDelay {
+		delay = maxExpireRetryDelay
+	}
+	c.retries++
+	c.retryAt = now.Add(delay)
+	return delay
+}
+
+// get all pending commands, the oldest first
+func (p *pendingCommands) list(now time.Time) []PendingCommand {
+	p.Lock()
+	res := make([]PendingCommand, 0, len(p.commands))
+	for _, c := range p.commands {
+		res = append(res, PendingCommand{
+			Id:     c.id,
+			Name:   c.name,
+			Device: c.deviceId,
+			Age:    uint64(now.Sub(c.received) / time.Millisecond),
+		})
+	}
+	p.Unlock()
+
+	sort.Sort(byAge(res))
+	return res
+}
+
+// get in-flight commands with their age
+func (w *DBusWrapper) GetPendingCommands() ([]PendingCommand, *dbus.Error) {
+	return w.pending.list(time.Now()), nil
+}
+
+// complete unanswered commands with "timeout" status
+func (w *DBusWrapper) expireLoop() {
+	for range time.Tick(pendingCheckInterval) {
+		if w.conn.Status().State != StateConnected {
+			continue // commands are expired once connected
+		}
+
+		timeout := w.conn.Config().CommandTimeout
+		now := time.Now()
+		for _, c := range w.pending.expired(now.Add(-timeout), now) {
+			clog := commandsLog.With("command", c.id)
+			if c.retries == 0 {
+				clog.Warnf("command(id=%d, name=%q) is not answered in %s", c.id, c.name, timeout)
+			}
+
+			err := w.postCommandResult(c.deviceId, c.id, commandStatusTimeout, nil)
+			switch {
+			case err == nil:
+			case c.retries+1 >= maxExpireRetries:
+				clog.Warnf("failed to expire command, giving up (error: %s)", err)
+				w.pending.remove(c.id)
+			default:
+				delay := w.pending.postpone(c.id, now)
+				clog.Debugf("failed to expire command, retrying in %s (error: %s)", delay, err)
+			}
+		}
+	}
+}
+
+// sort pending commands by age, the oldest first
+type byAge []PendingCommand
+
+func (a byAge) Len() int           { return len(a) }
+func (a byAge) Less(i, j int) bool { return a[i].Age > a[j].Age }
+func (a byAge) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/history"
+
+	"github.com/godbus/dbus"
+)
+
+const (
+	// reasons of suppressed notifications
+	droppedRateLimit  = "rate limit"
+	droppedDuplicate  = "duplicate"
+	droppedSuperseded = "superseded"
+)
+
+// state of notifications with the same device and name
+type policyState struct {
+	tokens    float64 // rate limit bucket
+	updated   time.Time
+	lastDat   []byte // the last accepted parameters
+	lastTime  time.Time
+	latestSeq uint64 // the last queued notification
+}
+
+// DroppedNotifications is a D-Bus representation of suppressed notifications counter
+type DroppedNotifications struct {
+	Device string // empty for the gateway device
+	Name   string
+	Reason string
+	Count  uint64
+}
+
+// suppressed notifications are counted by device, name and reason
+type droppedKey struct {
+	notificationKey
+	reason string
+}
+
+// per-name notification policies
+type notificationPolicies struct {
+	sync.Mutex
+	policies map[string]conf.NotificationPolicy
+	states   map[notificationKey]*policyState
+	dropped  map[droppedKey]uint64
+	seq      uint64
+}
+
+// create new policies
+func newNotificationPolicies(policies map[string]conf.NotificationPolicy) *notificationPolicies {
+	return &notificationPolicies{
+		policies: policies,
+		states:   make(map[notificationKey]*policyState),
+		dropped:  make(map[droppedKey]uint64),
+	}
+}
+
+// check duplicates and rate limit, returns false and the reason
+// if notification should be dropped
+func (p *notificationPolicies) accept(deviceId, name string, dat interface{}, now time.Time) (bool, string) {
+	p.Lock()
+	defer p.Unlock()
+
+	policy, ok := p.policies[name]
+	if !ok || (policy.MaxRate <= 0 && policy.DropDuplicates <= 0) {
+		return true, ""
+	}
+
+	key := notificationKey{deviceId: deviceId, name: name}
+	s := p.state(key, policy, now)
+
+	var buf []byte
+	if policy.DropDuplicates > 0 {
+		buf, _ = json.Marshal(dat)
+		if s.lastDat != nil && now.Sub(s.lastTime) < policy.DropDuplicates && string(buf) == string(s.lastDat) {
+			p.drop(key, droppedDuplicate)
+			return false, droppedDuplicate
+		}
+	}
+
+	if policy.MaxRate > 0 {
+		burst := float64(policy.Burst)
+		if burst < 1 {
+			burst = 1
+		}
+		s.tokens += now.Sub(s.updated).Seconds() * policy.MaxRate
+		if s.tokens > burst {
+			s.tokens = burst
+		}
+		s.updated = now
+		if s.tokens < 1 {
+			p.drop(key, droppedRateLimit)
+			return false, droppedRateLimit
+		}
+		s.tokens--
+	}
+
+	if policy.DropDuplicates > 0 {
+		s.lastDat = buf
+		s.lastTime = now
+	}
+	return true, ""
+}
+
+// track queued notification, returns its sequence number
+// or zero if all queued notifications should be sent
+func (p *notificationPolicies) queued(deviceId, name string) uint64 {
+	p.Lock()
+	defer p.Unlock()
+
+	policy, ok := p.policies[name]
+	if !ok || !policy.KeepLatestOnly {
+		return 0
+	}
+
+	key := notificationKey{deviceId: deviceId, name: name}
+	p.seq++
+	p.state(key, policy, time.Now()).latestSeq = p.seq
+	return p.seq
+}
+
+// get lifetime of queued notifications, zero if not specified
+func (p *notificationPolicies) ttl(name string) time.Duration {
+	p.Lock()
+	defer p.Unlock()
+	return p.policies[name].TTL
+}
+
+// change policies, counters are kept but limits start over
+func (p *notificationPolicies) setPolicies(policies map[string]conf.NotificationPolicy) {
+	p.Lock()
+	defer p.Unlock()
+	p.policies = policies
+	p.states = make(map[notificationKey]*policyState)
+}
+
+// check if a newer notification is queued, the older one is counted as dropped
+func (p *notificationPolicies) superseded(deviceId, name string, seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	key := notificationKey{deviceId: deviceId, name: name}
+	if s, ok := p.states[key]; ok && s.latestSeq != seq {
+		p.drop(key, droppedSuperseded)
+		return true
+	}
+	return false
+}
+
+// get or create state, should be called under lock
+func (p *notificationPolicies) state(key notificationKey, policy conf.NotificationPolicy, now time.Time) *policyState {
+	s, ok := p.states[key]
+	if !ok {
+		s = &policyState{tokens: float64(policy.Burst), updated: now}
+		if s.tokens < 1 {
+			s.tokens = 1
+		}
+		p.states[key] = s
+	}
+	return s
+}
+
+// count dropped notification, should be called under lock
+func (p *notificationPolicies) drop(key notificationKey, reason string) {
+	notificationsLog.Debugf("notification(device=%q, name=%q) is suppressed (reason: %s)", key.deviceId, key.name, reason)
+	p.dropped[droppedKey{notificationKey: key, reason: reason}]++
+	notificationsDropped.WithLabelValues(metricName(key.name)).Inc()
+}
+
+// get all counters sorted by device, name and reason
+func (p *notificationPolicies) list() []DroppedNotifications {
+	p.Lock()
+	res := make([]DroppedNotifications, 0, len(p.dropped))
+	for key, count := range p.dropped {
+		res = append(res, DroppedNotifications{
+			Device: key.deviceId,
+			Name:   key.name,
+			Reason: key.reason,
+			Count:  count,
+		})
+	}
+	p.Unlock()
+
+	sort.Sort(byDroppedKey(res))
+	return res
+}
+
+// check policies of the notification, suppressed one is recorded in history
+func (w *DBusWrapper) acceptNotification(deviceId, name string, dat interface{}) bool {
+	ok, reason := w.policies.accept(deviceId, name, dat, time.Now())
+	if !ok {
+		w.notificationSuppressed(deviceId, name, dat, reason)
+	}
+	return ok
+}
+
+// check if a newer notification is queued, superseded one is recorded in history
+func (w *DBusWrapper) supersededNotification(deviceId, name string, dat interface{}, seq uint64) bool {
+	if !w.policies.superseded(deviceId, name, seq) {
+		return false
+	}
+	w.notificationSuppressed(deviceId, name, dat, droppedSuperseded)
+	return true
+}
+
+// record notification suppressed by policies, it is counted already
+func (w *DBusWrapper) notificationSuppressed(deviceId, name string, dat interface{}, reason string) {
+	w.record(history.Entry{
+		Kind:       history.KindNotification,
+		Device:     deviceId,
+		Name:       name,
+		Parameters: dat,
+		Status:     history.StatusDropped,
+		Reason:     reason,
+	})
+}
+
+// get notifications suppressed by policies
+func (w *DBusWrapper) GetDroppedNotifications() ([]DroppedNotifications, *dbus.Error) {
+	return w.policies.list(), nil
+}
+
+// sort counters by device, name and reason
+type byDroppedKey []DroppedNotifications
+
+func (a byDroppedKey) Len() int      { return len(a) }
+func (a byDroppedKey) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byDroppedKey) Less(i, j int) bool {
+	if a[i].Device != a[j].Device {
+		return a[i].Device < a[j].Device
+	}
+	if a[i].Name != a[j].Name {
+		return a[i].Name < a[j].Name
+	}
+	return a[i].Reason < a[j].Reason
+}
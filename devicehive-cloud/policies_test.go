@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/history"
+)
+
+func TestPolicyAccept(t *testing.T) {
+	type notification struct {
+		at       time.Duration // since start
+		deviceId string
+		dat      interface{}
+		accepted bool
+	}
+
+	tests := []struct {
+		title         string
+		policy        conf.NotificationPolicy
+		notifications []notification
+		dropped       map[string]uint64 // by reason
+	}{
+		{
+			title:  "no limits",
+			policy: conf.NotificationPolicy{KeepLatestOnly: true},
+			notifications: []notification{
+				{at: 0, dat: 1.0, accepted: true},
+				{at: 0, dat: 1.0, accepted: true},
+			},
+		},
+		{
+			title:  "rate limit",
+			policy: conf.NotificationPolicy{MaxRate: 1},
+			notifications: []notification{
+				{at: 0, dat: 1.0, accepted: true},
+				{at: 500 * time.Millisecond, dat: 2.0, accepted: false},
+				{at: 1000 * time.Millisecond, dat: 3.0, accepted: true},
+				{at: 1500 * time.Millisecond, dat: 4.0, accepted: false},
+			},
+			dropped: map[string]uint64{droppedRateLimit: 2},
+		},
+		{
+			title:  "rate limit with burst",
+			policy: conf.NotificationPolicy{MaxRate: 0.5, Burst: 3},
+			notifications: []notification{
+				{at: 0, dat: 1.0, accepted: true},
+				{at: 0, dat: 2.0, accepted: true},
+				{at: 0, dat: 3.0, accepted: true},
+				{at: 0, dat: 4.0, accepted: false},
+				{at: 2 * time.Second, dat: 5.0, accepted: true},
+				{at: 3 * time.Second, dat: 6.0, accepted: false},
+				// bucket is never filled over the burst
+				{at: time.Minute, dat: 7.0, accepted: true},
+				{at: time.Minute, dat: 8.0, accepted: true},
+				{at: time.Minute, dat: 9.0, accepted: true},
+				{at: time.Minute, dat: 10.0, accepted: false},
+			},
+			dropped: map[string]uint64{droppedRateLimit: 3},
+		},
+		{
+			title:  "rate limit by device",
+			policy: conf.NotificationPolicy{MaxRate: 1},
+			notifications: []notification{
+				{at: 0, deviceId: "a", dat: 1.0, accepted: true},
+				{at: 0, deviceId: "b", dat: 1.0, accepted: true},
+				{at: 0, deviceId: "a", dat: 2.0, accepted: false},
+			},
+			dropped: map[string]uint64{droppedRateLimit: 1},
+		},
+		{
+			title:  "duplicates",
+			policy: conf.NotificationPolicy{DropDuplicates: 10 * time.Second},
+			notifications: []notification{
+				{at: 0, dat: map[string]interface{}{"on": true}, accepted: true},
+				{at: 5 * time.Second, dat: map[string]interface{}{"on": true}, accepted: false},
+				{at: 6 * time.Second, dat: map[string]interface{}{"on": false}, accepted: true},
+				{at: 7 * time.Second, dat: map[string]interface{}{"on": true}, accepted: true},
+				// the window starts at the last accepted one
+				{at: 17 * time.Second, dat: map[string]interface{}{"on": true}, accepted: true},
+			},
+			dropped: map[string]uint64{droppedDuplicate: 1},
+		},
+		{
+			title:  "rate limited notification is not a duplicate",
+			policy: conf.NotificationPolicy{MaxRate: 1, DropDuplicates: time.Minute},
+			notifications: []notification{
+				{at: 0, dat: 1.0, accepted: true},
+				{at: 100 * time.Millisecond, dat: 2.0, accepted: false},
+				{at: 1100 * time.Millisecond, dat: 2.0, accepted: true},
+				{at: 3 * time.Second, dat: 2.0, accepted: false},
+			},
+			dropped: map[string]uint64{droppedRateLimit: 1, droppedDuplicate: 1},
+		},
+	}
+
+	start := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, test := range tests {
+		p := newNotificationPolicies(map[string]conf.NotificationPolicy{"temp": test.policy})
+		for i, n := range test.notifications {
+			if ok, _ := p.accept(n.deviceId, "temp", n.dat, start.Add(n.at)); ok != n.accepted {
+				t.Errorf("%s: notification #%d accepted: %t, expected: %t", test.title, i, ok, n.accepted)
+			}
+		}
+
+		dropped := make(map[string]uint64)
+		for _, d := range p.list() {
+			dropped[d.Reason] += d.Count
+		}
+		if test.dropped == nil {
+			test.dropped = map[string]uint64{}
+		}
+		if !reflect.DeepEqual(dropped, test.dropped) {
+			t.Errorf("%s: dropped %v, expected: %v", test.title, dropped, test.dropped)
+		}
+	}
+}
+
+func TestPolicyOtherNames(t *testing.T) {
+	p := newNotificationPolicies(map[string]conf.NotificationPolicy{"temp": {MaxRate: 1}})
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := p.accept("", "event", 1.0, now); !ok {
+			t.Errorf("notification #%d without policy is dropped", i)
+		}
+	}
+}
+
+func TestPolicySuperseded(t *testing.T) {
+	policies := map[string]conf.NotificationPolicy{
+		"state": {KeepLatestOnly: true},
+	}
+	p := newNotificationPolicies(policies)
+
+	if seq := p.queued("", "event"); seq != 0 {
+		t.Errorf("notification without policy has sequence %d", seq)
+	}
+	if p.superseded("", "event", 0) {
+		t.Error("notification without policy is superseded")
+	}
+
+	first := p.queued("", "state")
+	other := p.queued("dev", "state")
+	second := p.queued("", "state")
+	switch {
+	case !p.superseded("", "state", first):
+		t.Error("older notification is not superseded")
+	case p.superseded("", "state", second):
+		t.Error("the latest notification is superseded")
+	case p.superseded("dev", "state", other):
+		t.Error("notification of other device is superseded")
+	}
+
+	expected := []DroppedNotifications{{Name: "state", Reason: droppedSuperseded, Count: 1}}
+	if res := p.list(); !reflect.DeepEqual(res, expected) {
+		t.Errorf("dropped %+v, expected: %+v", res, expected)
+	}
+}
+
+func TestPolicyDropHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	journal, err := history.Open(dir, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer journal.Close()
+
+	w := &DBusWrapper{
+		journal: journal,
+		policies: newNotificationPolicies(map[string]conf.NotificationPolicy{
+			"temp":  {MaxRate: 0.001},
+			"state": {KeepLatestOnly: true},
+		}),
+	}
+	dat := map[string]interface{}{"value": 1.0}
+	if !w.acceptNotification("dev", "temp", dat) || w.acceptNotification("dev", "temp", dat) {
+		t.Fatal("rate limit is not applied")
+	}
+	first := w.policies.queued("", "state")
+	w.policies.queued("", "state")
+	if !w.supersededNotification("", "state", dat, first) {
+		t.Fatal("older notification is not superseded")
+	}
+
+	entries, err := journal.Query(history.Filter{Status: history.StatusDropped}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	for i, expected := range []history.Entry{
+		{Device: "dev", Name: "temp", Reason: droppedRateLimit},
+		{Device: "", Name: "state", Reason: droppedSuperseded},
+	} {
+		e := entries[i]
+		if e.Kind != history.KindNotification || e.Device != expected.Device || e.Name != expected.Name ||
+			e.Reason != expected.Reason || !reflect.DeepEqual(e.Parameters, dat) {
+			t.Errorf("entry %+v, expected: %+v", e, expected)
+		}
+	}
+}
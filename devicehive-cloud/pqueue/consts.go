@@ -5,3 +5,7 @@ import "errors"
 const StandardQueueCapacity = 2048
 
 var ListenerShouldNotBeNil = errors.New("Out-channel should not be nil")
+
+var QueueIsClosed = errors.New("Queue is closed")
+
+var QueueIsFull = errors.New("Queue is full")
@@ -1,23 +1,41 @@
 package pqueue
 
-func (q *PriorityQueue) Push(x interface{}) {
-	q.cond.L.Lock()
-	q.items = append(q.items, x.(QueueItem))
-	q.cond.L.Unlock()
-	q.cond.Signal()
-}
+// container/heap interface, should be called under the queue lock
 
-func (q *PriorityQueue) Pop() interface{} {
-	q.cond.L.Lock()
+func (h *itemHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(*QueueItem))
+}
 
-	old := q.items
+func (h *itemHeap) Pop() interface{} {
+	old := h.items
 	n := len(old)
 
 	x := old[n-1]
-	q.items = old[0 : n-1]
-
-	q.cond.L.Unlock()
-	q.cond.Signal()
+	old[n-1] = nil
+	h.items = old[0 : n-1]
 
 	return x
 }
+
+// get index of the item sent last
+func (h *itemHeap) last() int {
+	n := h.Len()
+	res := n - 1
+	for i := n / 2; i < n; i++ { // it is always a leaf
+		if h.Less(res, i) {
+			res = i
+		}
+	}
+	return res
+}
+
+// get index of the item queued first
+func (h *itemHeap) oldest() int {
+	res := 0
+	for i := range h.items {
+		if h.items[i].seq < h.items[res].seq {
+			res = i
+		}
+	}
+	return res
+}
@@ -2,6 +2,7 @@ package pqueue
 
 import (
 	"container/heap"
+	"context"
 	"sync"
 	"time"
 )
@@ -10,17 +11,55 @@ import (
 type Message map[string]interface{}
 
 type QueueItem struct {
-	Msg       Message
-	Timestamp uint64
-	Priority  uint64
+	Msg      Message
+	Priority uint64
+	Queued   time.Time // when the item is sent to the queue
+	Deadline time.Time // zero if the item never expires
+
+	seq  uint64  // FIFO order
+	rank float64 // priority adjusted by aging
+}
+
+// check if the item is expired
+func (item *QueueItem) expired(now time.Time) bool {
+	return !item.Deadline.IsZero() && !now.Before(item.Deadline)
 }
 
+// Eviction selects the item removed when the queue is full
+type Eviction int
+
+const (
+	EvictLowest Eviction = iota // the item to be sent last (may be the new one)
+	EvictOldest                 // the item queued first regardless of priority
+)
+
+// Options of the queue, all are optional
+type Options struct {
+	Aging    time.Duration        // priority grows by one per interval in the queue, disabled if zero
+	TTL      time.Duration        // default item lifetime, unlimited if zero
+	Eviction Eviction             // EvictLowest by default
+	Expired  func(item QueueItem) // called for items expired in the queue
+}
+
+// all fields are guarded by the mutex
 type PriorityQueue struct {
-	items []QueueItem
-	cond  *sync.Cond
+	mutex    sync.Mutex
+	cond     *sync.Cond    // signaled on new items and on close
+	changed  chan struct{} // closed (and replaced) when items leave the queue
+	heap     itemHeap
+	seq      uint64
+	inFlight bool // an item is waiting to be received
+	closing  bool // new items are rejected
+	closed   bool // the delivery is stopped
+	stats    Stats
 
 	capacity uint64
+	options  Options
+	epoch    time.Time
+	now      func() time.Time
 	out      chan Message
+	done     chan struct{} // closed on Close
+	stopped  chan struct{} // closed when the delivery is stopped
 }
 
 func (pq *PriorityQueue) Out() chan Message {
@@ -28,40 +67,332 @@ func (pq *PriorityQueue) Out() chan Message {
 }
 
 func NewPriorityQueue(capacity uint64, listener chan Message) (*PriorityQueue, error) {
-	pq := PriorityQueue{}
+	return NewPriorityQueueOptions(capacity, listener, Options{})
+}
+
+// NewPriorityQueueOptions creates the queue with aging, TTL and eviction options
+func NewPriorityQueueOptions(capacity uint64, listener chan Message, options Options) (*PriorityQueue, error) {
+	return newPriorityQueue(capacity, listener, options, time.Now)
+}
+
+func newPriorityQueue(capacity uint64, listener chan Message, options Options, now func() time.Time) (*PriorityQueue, error) {
+	pq := &PriorityQueue{}
 	if listener == nil {
-		return &pq, ListenerShouldNotBeNil
+		return pq, ListenerShouldNotBeNil
 	}
 
-	pq.items = []QueueItem{}
-	pq.cond = &sync.Cond{L: &sync.Mutex{}}
+	pq.cond = sync.NewCond(&pq.mutex)
+	pq.changed = make(chan struct{})
+	pq.heap.aging = options.Aging > 0
 	pq.capacity = capacity
+	pq.options = options
+	pq.now = now
+	pq.epoch = now()
 	pq.out = listener
+	pq.done = make(chan struct{})
+	pq.stopped = make(chan struct{})
 
-	go func() {
-		defer func() { recover() }()
-		for {
-			pq.cond.L.Lock()
-			for pq.Len() == 0 {
-				pq.cond.Wait()
+	go pq.run()
+	return pq, nil
+}
+
+// pass items to the out-channel one by one until closed
+func (pq *PriorityQueue) run() {
+	defer close(pq.stopped)
+	for {
+		item, expired, ok := pq.next()
+		pq.expire(expired)
+		if !ok {
+			return
+		}
+		if item == nil {
+			continue
+		}
+
+		select {
+		case pq.out <- item.Msg:
+			pq.received()
+		case <-pq.done:
+			pq.requeue(item)
+			return
+		}
+	}
+}
+
+// wait for the next item, expired items are skipped,
+// returns false if the queue is closed
+func (pq *PriorityQueue) next() (*QueueItem, []QueueItem, bool) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	var expired []QueueItem
+	for {
+		for pq.heap.Len() == 0 && !pq.closed {
+			if len(expired) != 0 {
+				return nil, expired, true
 			}
-			pq.cond.L.Unlock()
-			item := heap.Pop(&pq).(QueueItem)
-			pq.out <- item.Msg
+			pq.cond.Wait()
+		}
+		if pq.closed {
+			return nil, expired, false
+		}
+
+		item := heap.Pop(&pq.heap).(*QueueItem)
+		if !item.expired(pq.now()) {
+			pq.inFlight = true
+			return item, expired, true
 		}
-	}()
-	return &pq, nil
+		pq.stats.Expired++
+		expired = append(expired, *item)
+		pq.notify()
+	}
+}
+
+// count received item
+func (pq *PriorityQueue) received() {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.inFlight = false
+	pq.stats.Delivered++
+	pq.notify()
+}
+
+// put back item which is not received
+func (pq *PriorityQueue) requeue(item *QueueItem) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.inFlight = false
+	heap.Push(&pq.heap, item)
+}
+
+// wake up waiting senders and drainers, should be called under lock
+func (pq *PriorityQueue) notify() {
+	close(pq.changed)
+	pq.changed = make(chan struct{})
+}
+
+// report expired items, should be called without lock
+func (pq *PriorityQueue) expire(items []QueueItem) {
+	if pq.options.Expired != nil {
+		for _, item := range items {
+			pq.options.Expired(item)
+		}
+	}
+}
+
+// Closed reports if new items are rejected (the queue is closed or drained)
+func (pq *PriorityQueue) Closed() bool {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	return pq.closing
+}
+
+// Len returns number of waiting items (including expired but not removed yet)
+func (pq *PriorityQueue) Len() int {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	return pq.heap.Len()
+}
+
+// SetCapacity changes the queue capacity, extra items are removed
+func (pq *PriorityQueue) SetCapacity(capacity uint64) (removed []QueueItem) {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+	pq.capacity = capacity
+	return pq.evict()
 }
 
+// Send queues the message with default TTL, returns items removed to fit the capacity
 func (pq *PriorityQueue) Send(m Message, priority uint64) (removed []QueueItem) {
-	for uint64(pq.Len()) > pq.capacity-1 {
-		item := heap.Remove(pq, pq.Len()-1).(QueueItem)
-		removed = append(removed, item)
-	}
-	heap.Push(pq, QueueItem{
-		Msg:       m,
-		Timestamp: uint64(time.Now().Unix()),
-		Priority:  priority,
-	})
+	return pq.SendTTL(m, priority, pq.options.TTL)
+}
+
+// SendTTL queues the message which expires after ttl (never if zero),
+// the message itself is returned if the queue is closed
+func (pq *PriorityQueue) SendTTL(m Message, priority uint64, ttl time.Duration) (removed []QueueItem) {
+	pq.mutex.Lock()
+	item := pq.newItem(m, priority, ttl)
+	if pq.closing {
+		pq.stats.Rejected++
+		pq.mutex.Unlock()
+		return []QueueItem{*item}
+	}
+
+	var expired []QueueItem
+	if uint64(pq.heap.Len()) >= pq.capacity {
+		expired = pq.purge()
+	}
+	pq.push(item)
+	removed = pq.evict()
+	pq.mutex.Unlock()
+
+	pq.expire(expired)
 	return
 }
+
+// TrySend queues the message with default TTL if there is room,
+// returns QueueIsFull or QueueIsClosed otherwise
+func (pq *PriorityQueue) TrySend(m Message, priority uint64) error {
+	pq.mutex.Lock()
+	item := pq.newItem(m, priority, pq.options.TTL)
+	expired, err := pq.tryPush(item)
+	if err != nil {
+		pq.stats.Rejected++
+	}
+	pq.mutex.Unlock()
+
+	pq.expire(expired)
+	return err
+}
+
+// SendWait queues the message with default TTL, waits for room if the queue is full,
+// returns QueueIsClosed or the context error if the message is not queued
+func (pq *PriorityQueue) SendWait(ctx context.Context, m Message, priority uint64) error {
+	for {
+		pq.mutex.Lock()
+		item := pq.newItem(m, priority, pq.options.TTL)
+		expired, err := pq.tryPush(item)
+		changed := pq.changed
+		pq.mutex.Unlock()
+
+		pq.expire(expired)
+		if err != QueueIsFull {
+			return err
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			pq.mutex.Lock()
+			pq.stats.Rejected++
+			pq.mutex.Unlock()
+			return ctx.Err()
+		}
+	}
+}
+
+// create new item, should be called under lock
+func (pq *PriorityQueue) newItem(m Message, priority uint64, ttl time.Duration) *QueueItem {
+	now := pq.now()
+	item := &QueueItem{Msg: m, Priority: priority, Queued: now}
+	if ttl > 0 {
+		item.Deadline = now.Add(ttl)
+	}
+	if pq.heap.aging {
+		// earlier items gain one per aging interval,
+		// so the order doesn't change over time
+		item.rank = float64(priority) - float64(now.Sub(pq.epoch))/float64(pq.options.Aging)
+	}
+	return item
+}
+
+// queue the item if there is room, should be called under lock
+func (pq *PriorityQueue) tryPush(item *QueueItem) (expired []QueueItem, err error) {
+	if pq.closing {
+		return nil, QueueIsClosed
+	}
+	if uint64(pq.heap.Len()) >= pq.capacity {
+		if expired = pq.purge(); uint64(pq.heap.Len()) >= pq.capacity {
+			return expired, QueueIsFull
+		}
+	}
+	pq.push(item)
+	return expired, nil
+}
+
+// queue the item, should be called under lock
+func (pq *PriorityQueue) push(item *QueueItem) {
+	pq.seq++
+	item.seq = pq.seq
+	heap.Push(&pq.heap, item)
+	pq.stats.Sent++
+	pq.cond.Signal()
+}
+
+// remove expired items, should be called under lock
+func (pq *PriorityQueue) purge() (expired []QueueItem) {
+	now := pq.now()
+	items := pq.heap.items[:0]
+	for _, item := range pq.heap.items {
+		if item.expired(now) {
+			expired = append(expired, *item)
+		} else {
+			items = append(items, item)
+		}
+	}
+	if len(expired) != 0 {
+		for i := len(items); i < len(pq.heap.items); i++ {
+			pq.heap.items[i] = nil
+		}
+		pq.heap.items = items
+		heap.Init(&pq.heap)
+		pq.stats.Expired += uint64(len(expired))
+		pq.notify()
+	}
+	return
+}
+
+// remove items over the capacity, should be called under lock
+func (pq *PriorityQueue) evict() (removed []QueueItem) {
+	for uint64(pq.heap.Len()) > pq.capacity {
+		i := pq.heap.last()
+		if pq.options.Eviction == EvictOldest {
+			i = pq.heap.oldest()
+		}
+		item := heap.Remove(&pq.heap, i).(*QueueItem)
+		removed = append(removed, *item)
+	}
+	if len(removed) != 0 {
+		pq.stats.Evicted += uint64(len(removed))
+		pq.notify()
+	}
+	return
+}
+
+// Close stops the delivery and closes the out-channel,
+// returns items left in the queue (in the delivery order)
+func (pq *PriorityQueue) Close() (left []QueueItem) {
+	pq.mutex.Lock()
+	if pq.closed {
+		pq.mutex.Unlock()
+		return nil
+	}
+	pq.closing = true
+	pq.closed = true
+	close(pq.done)
+	pq.cond.Broadcast()
+	pq.notify()
+	pq.mutex.Unlock()
+
+	<-pq.stopped
+	close(pq.out)
+
+	pq.mutex.Lock()
+	for pq.heap.Len() != 0 {
+		left = append(left, *heap.Pop(&pq.heap).(*QueueItem))
+	}
+	pq.mutex.Unlock()
+	return
+}
+
+// Drain rejects new items and waits until queued items are received,
+// then closes the queue, returns items left if the context is done first
+func (pq *PriorityQueue) Drain(ctx context.Context) ([]QueueItem, error) {
+	pq.mutex.Lock()
+	pq.closing = true
+	pq.notify() // waiting senders are rejected
+	for (pq.heap.Len() != 0 || pq.inFlight) && !pq.closed {
+		changed := pq.changed
+		pq.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return pq.Close(), ctx.Err()
+		}
+		pq.mutex.Lock()
+	}
+	pq.mutex.Unlock()
+
+	return pq.Close(), nil
+}
@@ -0,0 +1,496 @@
+package pqueue
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"testing/quick"
+	"time"
+)
+
+// manually advanced clock
+type testClock struct {
+	sync.Mutex
+	t time.Time
+}
+
+func (c *testClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+// create queue with the first item stuck in the out-channel,
+// so the following items are kept in the queue until received
+func newBlockedQueue(t *testing.T, capacity uint64, options Options) (*PriorityQueue, *testClock) {
+	clock := &testClock{t: time.Unix(1000000, 0)}
+	pq, err := newPriorityQueue(capacity, make(chan Message), options, clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pq.Send(Message{"i": -1}, 0)
+	for pq.Len() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return pq, clock
+}
+
+// receive n items after the blocking one
+func receive(t *testing.T, pq *PriorityQueue, n int) []int {
+	res := make([]int, 0, n)
+	for len(res) < n+1 {
+		select {
+		case m := <-pq.Out():
+			res = append(res, m["i"].(int))
+		case <-time.After(time.Second):
+			t.Fatalf("%d items expected, got %v", n, res)
+		}
+	}
+	if res[0] != -1 {
+		t.Fatalf("blocking item expected first, got %v", res)
+	}
+	return res[1:]
+}
+
+// sent item
+type sample struct {
+	i        int
+	priority uint64
+}
+
+// expected order: priority, FIFO within a priority
+func sorted(samples []sample) []int {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].priority > samples[j].priority
+	})
+	res := make([]int, len(samples))
+	for i, s := range samples {
+		res[i] = s.i
+	}
+	return res
+}
+
+func equal(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestNilListener(t *testing.T) {
+	if _, err := NewPriorityQueue(1, nil); err != ListenerShouldNotBeNil {
+		t.Errorf("nil listener should not be accepted, got %v", err)
+	}
+}
+
+func TestOrderProperty(t *testing.T) {
+	property := func(priorities []uint8) bool {
+		pq, _ := newBlockedQueue(t, uint64(len(priorities)+1), Options{})
+
+		samples := make([]sample, len(priorities))
+		for i, p := range priorities {
+			samples[i] = sample{i: i, priority: uint64(p % 4)} // many equal priorities
+			if removed := pq.Send(Message{"i": i}, samples[i].priority); len(removed) != 0 {
+				t.Logf("nothing should be removed, got %v", removed)
+				return false
+			}
+		}
+
+		res := receive(t, pq, len(samples))
+		if expected := sorted(samples); !equal(res, expected) {
+			t.Logf("expected %v, got %v", expected, res)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvictLowestProperty(t *testing.T) {
+	property := func(priorities []uint64, capacity uint8) bool {
+		c := int(capacity%8) + 1
+		pq, _ := newBlockedQueue(t, uint64(c), Options{Eviction: EvictLowest})
+
+		samples := make([]sample, len(priorities))
+		removed := 0
+		for i, p := range priorities {
+			samples[i] = sample{i: i, priority: p % 16}
+			removed += len(pq.Send(Message{"i": i}, samples[i].priority))
+		}
+
+		// the best items are kept
+		expected := sorted(samples)
+		if len(expected) > c {
+			expected = expected[:c]
+		}
+		if removed != len(samples)-len(expected) {
+			t.Logf("%d items should be removed, got %d", len(samples)-len(expected), removed)
+			return false
+		}
+
+		res := receive(t, pq, len(expected))
+		if !equal(res, expected) {
+			t.Logf("expected %v, got %v", expected, res)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvictOldestProperty(t *testing.T) {
+	property := func(priorities []uint64, capacity uint8) bool {
+		c := int(capacity%8) + 1
+		pq, _ := newBlockedQueue(t, uint64(c), Options{Eviction: EvictOldest})
+
+		samples := make([]sample, len(priorities))
+		for i, p := range priorities {
+			samples[i] = sample{i: i, priority: p}
+			pq.Send(Message{"i": i}, p)
+		}
+
+		// the newest items are kept
+		if len(samples) > c {
+			samples = samples[len(samples)-c:]
+		}
+
+		res := receive(t, pq, len(samples))
+		if expected := sorted(samples); !equal(res, expected) {
+			t.Logf("expected %v, got %v", expected, res)
+			return false
+		}
+		return true
+	}
+
+	if err := quick.Check(property, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetCapacity(t *testing.T) {
+	pq, _ := newBlockedQueue(t, 4, Options{})
+	for i, p := range []uint64{1, 3, 2, 4} {
+		pq.Send(Message{"i": i}, p)
+	}
+
+	removed := pq.SetCapacity(2)
+	if len(removed) != 2 || removed[0].Priority != 1 || removed[1].Priority != 2 {
+		t.Errorf("the lowest priority items should be removed, got %v", removed)
+	}
+	if res := receive(t, pq, 2); !equal(res, []int{3, 1}) {
+		t.Errorf("the highest priority items should be kept, got %v", res)
+	}
+}
+
+func TestAging(t *testing.T) {
+	pq, clock := newBlockedQueue(t, 4, Options{Aging: time.Second})
+
+	pq.Send(Message{"i": 0}, 1)
+	clock.advance(5 * time.Second) // 1 + 5 > 3
+	pq.Send(Message{"i": 1}, 3)
+	pq.Send(Message{"i": 2}, 10)
+	pq.Send(Message{"i": 3}, 3)
+
+	if res := receive(t, pq, 4); !equal(res, []int{2, 0, 1, 3}) {
+		t.Errorf("the waiting item should go first, got %v", res)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	var mutex sync.Mutex
+	var expired []int
+	options := Options{
+		TTL: time.Minute,
+		Expired: func(item QueueItem) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			expired = append(expired, item.Msg["i"].(int))
+		},
+	}
+	pq, clock := newBlockedQueue(t, 4, options)
+
+	pq.Send(Message{"i": 0}, 1)                 // default TTL
+	pq.SendTTL(Message{"i": 1}, 2, time.Hour)   // kept
+	pq.SendTTL(Message{"i": 2}, 3, time.Second) // expired
+	pq.SendTTL(Message{"i": 3}, 0, 0)           // never expires
+	clock.advance(2 * time.Minute)
+
+	// expired items are removed to fit the new one
+	if removed := pq.Send(Message{"i": 4}, 4); len(removed) != 0 {
+		t.Errorf("expired items should be removed first, got %v", removed)
+	}
+	if res := receive(t, pq, 3); !equal(res, []int{4, 1, 3}) {
+		t.Errorf("expired items should not be received, got %v", res)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	sort.Ints(expired)
+	if !equal(expired, []int{0, 2}) {
+		t.Errorf("expired items should be reported, got %v", expired)
+	}
+}
+
+func TestExpiredOnReceive(t *testing.T) {
+	reported := make(chan int, 1)
+	options := Options{Expired: func(item QueueItem) { reported <- item.Msg["i"].(int) }}
+	pq, clock := newBlockedQueue(t, 4, options)
+
+	pq.SendTTL(Message{"i": 0}, 1, time.Second)
+	pq.Send(Message{"i": 1}, 0)
+	clock.advance(time.Minute)
+
+	if res := receive(t, pq, 1); !equal(res, []int{1}) {
+		t.Errorf("expired item should be skipped, got %v", res)
+	}
+	if i := <-reported; i != 0 {
+		t.Errorf("expired item should be reported, got %d", i)
+	}
+}
+
+// get ids of the items
+func ids(items []QueueItem) []int {
+	res := make([]int, len(items))
+	for i, item := range items {
+		res[i] = item.Msg["i"].(int)
+	}
+	return res
+}
+
+func TestClose(t *testing.T) {
+	pq, _ := newBlockedQueue(t, 10, Options{})
+	pq.Send(Message{"i": 0}, 1)
+	pq.Send(Message{"i": 1}, 2)
+
+	left := pq.Close()
+	if got := ids(left); !equal(got, []int{1, 0, -1}) {
+		t.Fatalf("left items %v", got)
+	}
+	if _, ok := <-pq.Out(); ok {
+		t.Fatal("out-channel should be closed")
+	}
+	if left := pq.Close(); len(left) != 0 {
+		t.Fatalf("second close returned %v", ids(left))
+	}
+
+	if removed := pq.Send(Message{"i": 2}, 0); !equal(ids(removed), []int{2}) {
+		t.Fatalf("closed queue accepted item, removed %v", ids(removed))
+	}
+	if err := pq.TrySend(Message{"i": 3}, 0); err != QueueIsClosed {
+		t.Fatalf("TrySend: %v", err)
+	}
+	if err := pq.SendWait(context.Background(), Message{"i": 4}, 0); err != QueueIsClosed {
+		t.Fatalf("SendWait: %v", err)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	pq, err := NewPriorityQueue(10, make(chan Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		pq.Send(Message{"i": i}, 0)
+	}
+
+	var got []int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for m := range pq.Out() {
+			got = append(got, m["i"].(int))
+		}
+	}()
+
+	left, err := pq.Drain(context.Background())
+	if err != nil || len(left) != 0 {
+		t.Fatalf("Drain: %v, left %v", err, ids(left))
+	}
+	<-done
+	if !equal(got, []int{0, 1, 2, 3, 4}) {
+		t.Fatalf("received %v", got)
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	pq, _ := newBlockedQueue(t, 10, Options{})
+	pq.Send(Message{"i": 0}, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	left, err := pq.Drain(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("deadline error expected, got %v", err)
+	}
+	if got := ids(left); !equal(got, []int{-1, 0}) {
+		t.Fatalf("left items %v", got)
+	}
+}
+
+func TestTrySend(t *testing.T) {
+	pq, clock := newBlockedQueue(t, 2, Options{TTL: time.Second})
+	for i := 0; i < 2; i++ {
+		if err := pq.TrySend(Message{"i": i}, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pq.TrySend(Message{"i": 2}, 9); err != QueueIsFull {
+		t.Fatalf("QueueIsFull expected, got %v", err)
+	}
+
+	// expired items make room
+	clock.advance(time.Second)
+	if err := pq.TrySend(Message{"i": 3}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, pq, 1); !equal(got, []int{3}) {
+		t.Fatalf("received %v", got)
+	}
+}
+
+func TestSendWait(t *testing.T) {
+	pq, _ := newBlockedQueue(t, 1, Options{})
+	pq.Send(Message{"i": 0}, 0)
+
+	// cancelled while the queue is full
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := pq.SendWait(ctx, Message{"i": 1}, 0); err != context.DeadlineExceeded {
+		t.Fatalf("deadline error expected, got %v", err)
+	}
+
+	// unblocked when the item is received
+	res := make(chan error, 1)
+	go func() {
+		res <- pq.SendWait(context.Background(), Message{"i": 2}, 0)
+	}()
+	if got := receive(t, pq, 2); !equal(got, []int{0, 2}) {
+		t.Fatalf("received %v", got)
+	}
+	if err := <-res; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	pq, clock := newBlockedQueue(t, 2, Options{TTL: time.Second})
+	pq.Send(Message{"i": 0}, 0)
+	pq.SendTTL(Message{"i": 1}, 0, 0)
+	pq.Send(Message{"i": 2}, 1) // evicts 1
+	pq.TrySend(Message{"i": 3}, 0)
+	clock.advance(time.Second) // expires 0 and 2
+
+	receive(t, pq, 0)
+	for pq.Stats().Delivered != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	s := pq.Stats()
+	want := Stats{Depth: 0, Capacity: 2, Sent: 4, Delivered: 1, Evicted: 1, Expired: 2, Rejected: 1, Throughput: 1}
+	if s != want {
+		t.Fatalf("stats %+v, expected %+v", s, want)
+	}
+}
+
+func TestConcurrentSenders(t *testing.T) {
+	const senders, count = 8, 100
+	pq, err := NewPriorityQueue(16, make(chan Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(chan int)
+	go func() {
+		n := 0
+		for range pq.Out() {
+			n++
+		}
+		received <- n
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				m := Message{"i": i*count + j}
+				if err := pq.SendWait(context.Background(), m, uint64(j%3)); err != nil {
+					t.Error(err)
+				}
+				pq.Stats()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if left, err := pq.Drain(context.Background()); err != nil || len(left) != 0 {
+		t.Fatalf("Drain: %v, left %v", err, ids(left))
+	}
+	if n := <-received; n != senders*count {
+		t.Fatalf("%d items received, expected %d", n, senders*count)
+	}
+}
+
+func BenchmarkSendEvict(b *testing.B) {
+	pq, _ := NewPriorityQueue(1024, make(chan Message))
+	m := Message{}
+	r := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pq.Send(m, uint64(r.Intn(16)))
+	}
+}
+
+func BenchmarkSendEvictOldest(b *testing.B) {
+	pq, _ := NewPriorityQueueOptions(1024, make(chan Message), Options{Eviction: EvictOldest})
+	m := Message{}
+	r := rand.New(rand.NewSource(1))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pq.Send(m, uint64(r.Intn(16)))
+	}
+}
+
+func BenchmarkSendReceive(b *testing.B) {
+	pq, _ := NewPriorityQueueOptions(uint64(b.N), make(chan Message), Options{Aging: time.Second, TTL: time.Minute})
+	m := Message{}
+	r := rand.New(rand.NewSource(1))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < b.N; i++ {
+			<-pq.Out()
+		}
+		close(done)
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(pq.Send(m, uint64(r.Intn(16)))) != 0 {
+			b.Fatal("nothing should be removed")
+		}
+	}
+	<-done
+}
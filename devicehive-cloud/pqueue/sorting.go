@@ -1,16 +1,28 @@
 package pqueue
 
-func (q PriorityQueue) Len() int {
-	return len(q.items)
+// items are ordered by priority (adjusted by aging), FIFO within a priority
+type itemHeap struct {
+	items []*QueueItem
+	aging bool
 }
 
-func (q PriorityQueue) Less(i, j int) bool {
-	return q.items[i].Timestamp*q.items[i].Priority > q.items[j].Timestamp*q.items[j].Priority
+func (h itemHeap) Len() int {
+	return len(h.items)
 }
 
-func (q PriorityQueue) Swap(i, j int) {
-	if (i > len(q.items)-1) || (j > len(q.items)-1) || (i < 0) || (j < 0) {
-		return
+// the item sent first is the "least" one
+func (h itemHeap) Less(i, j int) bool {
+	a, b := h.items[i], h.items[j]
+	if h.aging {
+		if a.rank != b.rank {
+			return a.rank > b.rank
+		}
+	} else if a.Priority != b.Priority {
+		return a.Priority > b.Priority
 	}
-	q.items[i], q.items[j] = q.items[j], q.items[i]
+	return a.seq < b.seq
+}
+
+func (h itemHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
 }
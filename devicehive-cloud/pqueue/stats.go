@@ -0,0 +1,27 @@
+package pqueue
+
+// Stats is a snapshot of the queue counters
+type Stats struct {
+	Depth      int     // waiting items
+	Capacity   uint64  // maximum number of waiting items
+	Sent       uint64  // queued items
+	Delivered  uint64  // items received from the out-channel
+	Evicted    uint64  // items removed to fit the capacity
+	Expired    uint64  // items removed by TTL
+	Rejected   uint64  // items not queued (the queue is full or closed)
+	Throughput float64 // delivered items per second since the queue is created
+}
+
+// Stats returns the current counters
+func (pq *PriorityQueue) Stats() Stats {
+	pq.mutex.Lock()
+	defer pq.mutex.Unlock()
+
+	s := pq.stats
+	s.Depth = pq.heap.Len()
+	s.Capacity = pq.capacity
+	if elapsed := pq.now().Sub(pq.epoch); elapsed > 0 {
+		s.Throughput = float64(s.Delivered) / elapsed.Seconds()
+	}
+	return s
+}
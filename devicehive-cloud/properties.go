@@ -0,0 +1,64 @@
+package main
+
+import (
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+
+	"github.com/godbus/dbus"
+	"github.com/godbus/dbus/introspect"
+	"github.com/godbus/dbus/prop"
+)
+
+// export org.freedesktop.DBus.Properties of the main object
+func (w *DBusWrapper) exportProperties(bus *dbus.Conn, config conf.Conf) []introspect.Property {
+	status := w.conn.Status()
+	props := map[string]*prop.Prop{
+		"ConnectionState":     {Value: status.State, Emit: prop.EmitTrue},
+		"LastError":           {Value: status.LastError, Emit: prop.EmitTrue},
+		"ServerURL":           {Value: config.URL, Emit: prop.EmitTrue},
+		"DeviceID":            {Value: config.DeviceID, Emit: prop.EmitTrue},
+		"OutboundQueueLength": {Value: w.queueLength(), Emit: prop.EmitFalse}, // changes too often
+		"LastServerTimestamp": {Value: status.LastTimestamp, Emit: prop.EmitTrue},
+	}
+
+	w.props = prop.New(bus, ComDevicehiveCloudPath,
+		map[string]map[string]*prop.Prop{ComDevicehiveCloudIface: props})
+	return w.props.Introspection(ComDevicehiveCloudIface)
+}
+
+// set property value, PropertiesChanged is emitted only if value is changed
+func (w *DBusWrapper) setProperty(name string, value interface{}) {
+	if w.props == nil {
+		return // not exported yet
+	}
+	if w.props.GetMust(ComDevicehiveCloudIface, name) != value {
+		w.props.SetMust(ComDevicehiveCloudIface, name, value)
+	}
+}
+
+// update properties on connection status change
+func (w *DBusWrapper) onConnectionChanged(status connectionStatus, transition bool) {
+	w.setProperty("ConnectionState", status.State)
+	w.setProperty("LastError", status.LastError)
+	w.setProperty("LastServerTimestamp", status.LastTimestamp)
+
+	if transition {
+		log.Debugf("emitting ConnectionStateChanged(%q, %q)", status.State, status.LastError)
+		w.bus.Emit(ComDevicehiveCloudPath, ComDevicehiveCloudIface+".ConnectionStateChanged",
+			status.State, status.LastError)
+	}
+}
+
+// get total number of outbound notifications
+func (w *DBusWrapper) queueLength() uint32 {
+	n := w.queue.Len()
+	if w.spool != nil {
+		n += w.spool.Len()
+	}
+	return uint32(n)
+}
+
+// update OutboundQueueLength property
+func (w *DBusWrapper) updateQueueLength() {
+	w.setProperty("OutboundQueueLength", w.queueLength())
+}
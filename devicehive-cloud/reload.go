@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/transport"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+
+	"github.com/godbus/dbus"
+)
+
+// reads configuration again
+type configLoader func() (conf.Conf, error)
+
+// re-read configuration and apply changes
+// returns settings applied live and settings requiring restart
+func (w *DBusWrapper) Reload() ([]string, []string, *dbus.Error) {
+	applied, restart, err := w.reload()
+	if err != nil {
+		log.Warnf("failed to reload configuration (error: %s)", err)
+		return nil, nil, newDHError(err.Error())
+	}
+	return applied, restart, nil
+}
+
+// re-read configuration, diff it against the running one and apply changes
+func (w *DBusWrapper) reload() (applied, restart []string, err error) {
+	w.reloadMutex.Lock()
+	defer w.reloadMutex.Unlock()
+	applied, restart, _, err = w.reloadLocked()
+	return
+}
+
+// re-read configuration, returns true if reconnect is requested
+// should be called under reloadMutex
+func (w *DBusWrapper) reloadLocked() (applied, restart []string, reconnect bool, err error) {
+	config, err := w.loadConfig()
+	if err != nil {
+		return
+	}
+
+	// everything that may fail goes first, so nothing is applied on error
+	validator, err := newPayloadValidator(config)
+	if err != nil {
+		return
+	}
+	if _, err = transport.TLSConfig(config.TLS); err != nil {
+		return
+	}
+	if _, err = transport.ProxyFunc(config.Proxy); err != nil {
+		return
+	}
+	if _, err = queueOptions(config); err != nil {
+		return
+	}
+	if err = checkLogging(config); err != nil {
+		return
+	}
+
+	old := w.conn.Config()
+	for _, name := range conf.Diff(old, config) {
+		switch name {
+		case "LoggingLevel", "LoggingFormat", "LoggingLevels":
+			applyLogging(config)
+		case "SendNotificatonQueueCapacity":
+			for _, item := range w.queue.SetCapacity(config.SendNotificatonQueueCapacity) {
+				w.notificationDropped(queuedRecord(item), "outbound queue is full")
+			}
+			w.updateQueueLength()
+		case "MetricsNames":
+			setMetricNames(config.MetricsNames)
+		case "Aggregation":
+			w.aggregator.setWindows(config.Aggregation)
+		case "Policies":
+			w.policies.setPolicies(config.Policies)
+		case "NotificationSchemas", "CommandResultSchemas", "SchemaValidation":
+			w.validator.update(validator)
+		case "CommandHandlerTimeout", "FailUnhandledCommands", "CommandTimeout", "NotificationTTL",
+			"ShutdownTimeout", "ConfigGracePeriod":
+			// used directly from the running configuration
+
+		case "Backend", "MQTT", "TLS", "Proxy", "URL", "AccessKey",
+			"DeviceID", "DeviceName", "DeviceKey",
+			"NetworkName", "NetworkKey", "NetworkDescription",
+			"DeviceClass", "Equipment", "DeviceData":
+			reconnect = true // devices are registered again
+
+		case "SpoolDir", "SpoolMaxSize", "SpoolMaxAge", "MetricsAddr", "QueueAging", "QueueEviction",
+			"FileDir", "FileMaxSize", "HistoryDir", "HistoryMaxSize", "HistoryFiles":
+			restart = append(restart, name)
+			continue
+		}
+		applied = append(applied, name)
+	}
+
+	// settings applied on restart only, keep running values
+	config.SpoolDir = old.SpoolDir
+	config.SpoolMaxSize = old.SpoolMaxSize
+	config.SpoolMaxAge = old.SpoolMaxAge
+	config.MetricsAddr = old.MetricsAddr
+	config.QueueAging = old.QueueAging
+	config.QueueEviction = old.QueueEviction
+	config.FileDir = old.FileDir
+	config.FileMaxSize = old.FileMaxSize
+	config.HistoryDir = old.HistoryDir
+	config.HistoryMaxSize = old.HistoryMaxSize
+	config.HistoryFiles = old.HistoryFiles
+
+	// schema files may be changed while file names are the same
+	if w.validator.changed(validator) {
+		w.validator.update(validator)
+		applied = append(applied, "schemas")
+	}
+
+	w.conn.Reconfigure(config, reconnect)
+	w.setProperty("ServerURL", config.URL)
+	w.setProperty("DeviceID", config.DeviceID)
+
+	log.Infof("configuration is reloaded (applied: %v, restart required: %v)", applied, restart)
+	return
+}
+
+// reload configuration on SIGHUP
+func (w *DBusWrapper) reloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		log.Infof("SIGHUP received, reloading configuration")
+		if _, _, err := w.reload(); err != nil {
+			log.Warnf("failed to reload configuration (error: %s)", err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+)
+
+// how often the connection is checked after remote configuration change
+const connectCheckInterval = 1 * time.Second
+
+// patch configuration file with the command parameters: {"LoggingLevel": "debug", ...}
+// the previous file is restored if the server is not reachable during the grace period
+func (w *DBusWrapper) gatewayConfigSet(params interface{}) (interface{}, error) {
+	patch, ok := params.(map[string]interface{})
+	if !ok || len(patch) == 0 {
+		return nil, errors.New("settings are expected as object")
+	}
+	if len(w.configFile) == 0 {
+		return nil, errors.New("no configuration file provided")
+	}
+
+	w.reloadMutex.Lock()
+	if w.configChanging {
+		w.reloadMutex.Unlock()
+		return nil, errors.New("previous configuration change is not confirmed yet")
+	}
+	previous, err := ioutil.ReadFile(w.configFile)
+	if err != nil {
+		w.reloadMutex.Unlock()
+		return nil, err
+	}
+	content, err := conf.Patch(previous, patch)
+	if err != nil {
+		w.reloadMutex.Unlock()
+		return nil, fmt.Errorf("invalid settings (%s)", err)
+	}
+	// rolled back on start if the service is restarted before confirmation
+	if err = conf.BeginChange(w.configFile, previous); err != nil {
+		w.reloadMutex.Unlock()
+		return nil, err
+	}
+	if err = conf.WriteFile(w.configFile, content); err != nil {
+		w.confirmConfig()
+		w.reloadMutex.Unlock()
+		return nil, err
+	}
+
+	changed := time.Now()
+	applied, restart, reconnect, err := w.reloadLocked()
+	if err != nil {
+		// nothing is applied
+		w.restoreConfig(previous)
+		w.reloadMutex.Unlock()
+		return nil, err
+	}
+	log.Infof("configuration is changed remotely (applied: %v, restart required: %v)", applied, restart)
+	w.configChanging = reconnect
+	if !reconnect {
+		w.confirmConfig() // the connection is not affected
+	}
+	w.reloadMutex.Unlock()
+
+	result := map[string]interface{}{"applied": applied, "restart": restart}
+	if !reconnect {
+		return result, nil
+	}
+
+	grace := w.conn.Config().ConfigGracePeriod
+	confirmed := w.waitConnected(changed, grace)
+
+	w.reloadMutex.Lock()
+	w.configChanging = false
+	if confirmed {
+		w.confirmConfig()
+		w.reloadMutex.Unlock()
+		return result, nil
+	}
+
+	log.Warnf("server is not reachable in %s, rolling back configuration", grace)
+	w.restoreConfig(previous)
+	changed = time.Now()
+	_, _, _, err = w.reloadLocked()
+	w.reloadMutex.Unlock()
+	if err != nil {
+		log.Warnf("failed to roll back configuration (error: %s)", err)
+		return nil, err
+	}
+
+	w.waitConnected(changed, grace) // to post the result
+	return nil, fmt.Errorf("server is not reachable in %s, configuration is rolled back", grace)
+}
+
+// write previous configuration file back, it is restored
+// on start if it fails
+func (w *DBusWrapper) restoreConfig(content []byte) {
+	if err := conf.WriteFile(w.configFile, content); err != nil {
+		log.Warnf("failed to restore configuration file %q (error: %s)", w.configFile, err)
+		return
+	}
+	w.confirmConfig()
+}
+
+// configuration change is confirmed or rolled back
+func (w *DBusWrapper) confirmConfig() {
+	if err := conf.ConfirmChange(w.configFile); err != nil {
+		log.Warnf("failed to confirm configuration change of %q (error: %s)", w.configFile, err)
+	}
+}
+
+// wait for a session established after the time, returns false on timeout
+func (w *DBusWrapper) waitConnected(after time.Time, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if at := w.conn.ConnectedAt(); !at.IsZero() && at.After(after) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(connectCheckInterval)
+	}
+}
@@ -0,0 +1,118 @@
+package schema
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// Validator checks JSON values against JSON Schema files
+// (notification or command names are mapped to schema files)
+type Validator struct {
+	schemas map[string]*jsonschema.Schema
+	digest  string // of names and schema file contents
+}
+
+// Error describes all failures of a value
+type Error struct {
+	Failures []Failure
+}
+
+// Failure of a single value, Path is a JSON pointer ("/" for the root value)
+type Failure struct {
+	Path    string
+	Message string
+}
+
+// Load compiles all schema files
+func Load(files map[string]string) (*Validator, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	v := &Validator{schemas: make(map[string]*jsonschema.Schema, len(files))}
+	h := sha256.New()
+	for _, name := range names {
+		buf, err := ioutil.ReadFile(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%q schema: %s", name, err)
+		}
+		s, err := jsonschema.Compile(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("%q schema: %s", name, err)
+		}
+		v.schemas[name] = s
+		fmt.Fprintf(h, "%q %d\n", name, len(buf))
+		h.Write(buf)
+	}
+	v.digest = hex.EncodeToString(h.Sum(nil))
+	return v, nil
+}
+
+// Digest identifies loaded schemas, it is changed if any schema file
+// content is changed (even if file names are the same)
+func (v *Validator) Digest() string {
+	return v.digest
+}
+
+// Has checks if schema for the name is defined
+func (v *Validator) Has(name string) bool {
+	_, ok := v.schemas[name]
+	return ok
+}
+
+// Validate checks the value (as produced by encoding/json),
+// returns *Error if the value does not conform to the schema
+// values without schema are always valid
+func (v *Validator) Validate(name string, dat interface{}) error {
+	s, ok := v.schemas[name]
+	if !ok {
+		return nil
+	}
+
+	err := s.Validate(dat)
+	if ve, ok := err.(*jsonschema.ValidationError); ok {
+		res := &Error{}
+		res.collect(ve)
+		sort.Stable(byPath(res.Failures))
+		return res
+	}
+	return err
+}
+
+// collect the most specific failures
+func (e *Error) collect(ve *jsonschema.ValidationError) {
+	if len(ve.Causes) == 0 {
+		path := ve.InstanceLocation
+		if len(path) == 0 {
+			path = "/"
+		}
+		e.Failures = append(e.Failures, Failure{Path: path, Message: ve.Message})
+		return
+	}
+	for _, cause := range ve.Causes {
+		e.collect(cause)
+	}
+}
+
+func (e *Error) Error() string {
+	s := make([]string, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		s = append(s, fmt.Sprintf("%s: %s", f.Path, f.Message))
+	}
+	return strings.Join(s, "; ")
+}
+
+// sort failures by path
+type byPath []Failure
+
+func (a byPath) Len() int           { return len(a) }
+func (a byPath) Less(i, j int) bool { return a[i].Path < a[j].Path }
+func (a byPath) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
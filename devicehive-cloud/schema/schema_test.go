@@ -0,0 +1,170 @@
+package schema
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const temperatureSchema = `{
+	"type": "object",
+	"required": ["value"],
+	"properties": {
+		"value": {"type": "number", "minimum": -50, "maximum": 150},
+		"unit": {"enum": ["C", "F"]}
+	},
+	"additionalProperties": false
+}`
+
+// write schema files into temporary directory
+func writeSchemas(t *testing.T, schemas map[string]string) (string, map[string]string) {
+	dir, err := ioutil.TempDir("", "schema")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string, len(schemas))
+	for name, content := range schemas {
+		file := filepath.Join(dir, name+".json")
+		if err = ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files[name] = file
+	}
+	return dir, files
+}
+
+// parse JSON as encoding/json does
+func parse(t *testing.T, s string) interface{} {
+	var dat interface{}
+	if err := json.Unmarshal([]byte(s), &dat); err != nil {
+		t.Fatal(err)
+	}
+	return dat
+}
+
+func TestValidate(t *testing.T) {
+	dir, files := writeSchemas(t, map[string]string{"temperature": temperatureSchema})
+	defer os.RemoveAll(dir)
+
+	v, err := Load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Has("temperature") || v.Has("humidity") {
+		t.Error("unexpected schema names")
+	}
+
+	tests := []struct {
+		name     string
+		dat      string
+		failures []string // paths, nil if valid
+	}{
+		{"temperature", `{"value": 21.5, "unit": "C"}`, nil},
+		{"temperature", `{"value": 21.5}`, nil},
+		{"temperature", `{"unit": "C"}`, []string{"/"}},
+		{"temperature", `{"value": "hot"}`, []string{"/value"}},
+		{"temperature", `{"value": 200, "unit": "K"}`, []string{"/unit", "/value"}},
+		{"temperature", `{"value": 1, "extra": true}`, []string{"/"}},
+		{"temperature", `42`, []string{"/"}},
+		{"humidity", `"anything"`, nil}, // no schema
+	}
+
+	for _, test := range tests {
+		err := v.Validate(test.name, parse(t, test.dat))
+		if test.failures == nil {
+			if err != nil {
+				t.Errorf("%s %s: unexpected error %s", test.name, test.dat, err)
+			}
+			continue
+		}
+
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("%s %s: expected validation error, got %v", test.name, test.dat, err)
+			continue
+		}
+		var paths []string
+		for _, f := range e.Failures {
+			if len(f.Message) == 0 {
+				t.Errorf("%s %s: empty failure message at %q", test.name, test.dat, f.Path)
+			}
+			paths = append(paths, f.Path)
+		}
+		if !reflect.DeepEqual(paths, test.failures) {
+			t.Errorf("%s %s: failures at %v, expected: %v", test.name, test.dat, paths, test.failures)
+		}
+	}
+}
+
+func TestErrorString(t *testing.T) {
+	e := &Error{Failures: []Failure{
+		{Path: "/unit", Message: "value must be one of \"C\", \"F\""},
+		{Path: "/value", Message: "must be <= 150"},
+	}}
+	expected := `/unit: value must be one of "C", "F"; /value: must be <= 150`
+	if e.Error() != expected {
+		t.Errorf("unexpected error string %q", e.Error())
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir, files := writeSchemas(t, map[string]string{"broken": `{"type": 42}`})
+	defer os.RemoveAll(dir)
+
+	if _, err := Load(files); err == nil {
+		t.Error("invalid schema is loaded")
+	}
+	if _, err := Load(map[string]string{"missing": filepath.Join(dir, "missing.json")}); err == nil {
+		t.Error("missing schema file is loaded")
+	}
+
+	v, err := Load(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Validate("any", 1.0); err != nil {
+		t.Errorf("value without schema is invalid (error: %s)", err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	dir, files := writeSchemas(t, map[string]string{"temperature": temperatureSchema})
+	defer os.RemoveAll(dir)
+
+	a, err := Load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Digest() != b.Digest() {
+		t.Error("digest of the same schemas is changed")
+	}
+
+	// the same file name, new content
+	if err = ioutil.WriteFile(files["temperature"], []byte(`{"type": "number"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Digest() == a.Digest() {
+		t.Error("digest is not changed with schema content")
+	}
+
+	// the same file, other name
+	d, err := Load(map[string]string{"humidity": files["temperature"]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Digest() == c.Digest() {
+		t.Error("digest is not changed with schema name")
+	}
+}
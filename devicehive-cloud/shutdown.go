@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/spool"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+)
+
+// send queued notifications and exit on SIGTERM or SIGINT
+func (w *DBusWrapper) shutdownOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-ch
+	signal.Stop(ch)
+
+	timeout := w.conn.Config().ShutdownTimeout
+	log.Infof("%s received, sending %d queued notifications (timeout: %s)", sig, w.queue.Len(), timeout)
+	w.shutdown(timeout)
+	os.Exit(0)
+}
+
+// stop the outbound queue, notifications not sent in time are spooled or dropped
+func (w *DBusWrapper) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	w.aggregator.flushAll()
+	left, err := w.queue.Drain(ctx)
+	if err != nil {
+		log.Warnf("%d notifications are not sent (error: %s)", len(left), err)
+	}
+
+	// the last one is being sent, it may be sent twice
+	// if it is spooled and the request completes anyway
+	select {
+	case <-w.sendDone:
+	case <-ctx.Done():
+		if record := w.takeSending(); record != nil {
+			log.Warnf("notification(name=%q) is not sent in time", record.Name)
+			w.spoolOrDrop(*record)
+		}
+	}
+
+	for _, item := range left {
+		w.spoolOrDrop(queuedRecord(item))
+	}
+	w.updateQueueLength()
+}
+
+// keep the notification for the next start if spool is enabled
+func (w *DBusWrapper) spoolOrDrop(record spool.Record) {
+	if w.spool == nil {
+		w.notificationDropped(record, "service is stopping")
+		return
+	}
+	w.store(record)
+}
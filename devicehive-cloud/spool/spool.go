@@ -0,0 +1,228 @@
+package spool
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileExt = ".json"
+	tmpExt  = ".tmp" // record being written
+)
+
+// Record is a notification stored on disk
+type Record struct {
+	Device     string      `json:"device,omitempty"`
+	Name       string      `json:"name"`
+	Parameters interface{} `json:"parameters,omitempty"`
+	Priority   uint64      `json:"priority"`
+	Timestamp  time.Time   `json:"timestamp"`
+
+	seq uint64
+}
+
+// on-disk journal entry
+type entry struct {
+	seq       uint64
+	size      int64
+	timestamp time.Time
+}
+
+// Spool is a persistent FIFO journal of notifications.
+// Each record is stored in a separate file named by its sequence number,
+// so the journal survives daemon restarts.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu      sync.Mutex
+	entries []entry
+	size    int64
+	nextSeq uint64
+}
+
+// Open creates the spool directory (if needed) and loads existing records.
+// Zero maxSize or maxAge means no limit.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Spool{dir: dir, maxSize: maxSize, maxAge: maxAge, nextSeq: 1}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.HasSuffix(f.Name(), fileExt+tmpExt) {
+			// incomplete record (power loss during write)
+			os.Remove(filepath.Join(dir, f.Name()))
+			continue
+		}
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(f.Name(), fileExt), 10, 64)
+		if err != nil {
+			continue // not a journal file
+		}
+		r, err := s.read(seq)
+		if err != nil {
+			// corrupted record (probably power loss during write)
+			os.Remove(s.path(seq))
+			continue
+		}
+
+		s.entries = append(s.entries, entry{seq: seq, size: f.Size(), timestamp: r.Timestamp})
+		s.size += f.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Sort(bySeq(s.entries))
+
+	return s, nil
+}
+
+// Len returns the number of stored records
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
+
+// Size returns the total size of stored records in bytes
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Append stores a new record at the tail of the journal.
+// Records exceeding the size or age limits are removed from the head
+// and returned.
+func (s *Spool) Append(r Record) (removed []Record, err error) {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.nextSeq
+	tmp := s.path(seq) + tmpExt
+	if err = ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		os.Remove(tmp)
+		return
+	}
+	if err = os.Rename(tmp, s.path(seq)); err != nil {
+		os.Remove(tmp)
+		return
+	}
+
+	s.nextSeq++
+	s.entries = append(s.entries, entry{seq: seq, size: int64(len(buf)), timestamp: r.Timestamp})
+	s.size += int64(len(buf))
+
+	removed = s.prune(time.Now())
+	return
+}
+
+// Peek returns the oldest record.
+// Records exceeding the age limit are removed and returned as well.
+func (s *Spool) Peek() (r *Record, expired []Record, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired = s.prune(time.Now())
+	for len(s.entries) != 0 {
+		if r, err = s.read(s.entries[0].seq); err == nil {
+			break
+		}
+		// skip unreadable record
+		if err = s.removeHead(); err != nil {
+			break
+		}
+	}
+	return
+}
+
+// Remove deletes the record returned by Peek
+func (s *Spool) Remove(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) == 0 || s.entries[0].seq != r.seq {
+		return nil // already removed by limits
+	}
+	return s.removeHead()
+}
+
+// remove records exceeding limits, should be called under lock
+func (s *Spool) prune(now time.Time) (removed []Record) {
+	for len(s.entries) > 0 {
+		head := s.entries[0]
+		tooBig := s.maxSize > 0 && s.size > s.maxSize
+		tooOld := s.maxAge > 0 && now.Sub(head.timestamp) > s.maxAge
+		if !tooBig && !tooOld {
+			break
+		}
+
+		if r, err := s.read(head.seq); err == nil {
+			removed = append(removed, *r)
+		}
+		if s.removeHead() != nil {
+			break
+		}
+	}
+	return
+}
+
+// remove the oldest record, should be called under lock
+func (s *Spool) removeHead() error {
+	head := s.entries[0]
+	if err := os.Remove(s.path(head.seq)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.entries = s.entries[1:]
+	s.size -= head.size
+	return nil
+}
+
+// read record from disk
+func (s *Spool) read(seq uint64) (*Record, error) {
+	buf, err := ioutil.ReadFile(s.path(seq))
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Record)
+	if err = json.Unmarshal(buf, r); err != nil {
+		return nil, err
+	}
+	r.seq = seq
+	return r, nil
+}
+
+// get record's file path
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, fileExt))
+}
+
+// sort entries by sequence number
+type bySeq []entry
+
+func (a bySeq) Len() int           { return len(a) }
+func (a bySeq) Less(i, j int) bool { return a[i].seq < a[j].seq }
+func (a bySeq) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
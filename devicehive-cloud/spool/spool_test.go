@@ -0,0 +1,133 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// create temporary spool directory
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// take all records in order
+func drain(t *testing.T, s *Spool) (names []string) {
+	for {
+		r, _, err := s.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r == nil {
+			return
+		}
+		names = append(names, r.Name)
+		if err = s.Remove(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOrderAndRestart(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err = s.Append(Record{Name: name, Timestamp: now}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// incomplete record is removed on open
+	tmp := filepath.Join(dir, "00000000000000000004.json.tmp")
+	ioutil.WriteFile(tmp, []byte("{"), 0600)
+
+	s, err = Open(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 3 {
+		t.Fatalf("3 records expected after restart, got %d", s.Len())
+	}
+	if _, err = os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatal("temporary file should be removed")
+	}
+
+	s.Append(Record{Name: "d", Timestamp: now})
+	names := drain(t, s)
+	if len(names) != 4 || names[0] != "a" || names[3] != "d" {
+		t.Fatalf("records out of order: %v", names)
+	}
+	if s.Len() != 0 || s.Size() != 0 {
+		t.Fatalf("spool should be empty: %d records, %d bytes", s.Len(), s.Size())
+	}
+}
+
+func TestSizeLimit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 300, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []Record
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		r, err := s.Append(Record{Name: name, Parameters: "0123456789", Timestamp: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		removed = append(removed, r...)
+	}
+
+	if s.Size() > 300 {
+		t.Fatalf("size %d exceeds the limit", s.Size())
+	}
+	if len(removed) == 0 || removed[0].Name != "a" {
+		t.Fatalf("the oldest records should be removed, got %+v", removed)
+	}
+	if names := drain(t, s); len(names)+len(removed) != 6 || names[len(names)-1] != "f" {
+		t.Fatalf("unexpected records left: %v", names)
+	}
+}
+
+func TestAgeLimit(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := s.Append(Record{Name: "old", Timestamp: time.Now().Add(-2 * time.Hour)})
+	if err != nil || len(removed) != 1 || removed[0].Name != "old" {
+		t.Fatalf("old record should be removed, got %+v (error: %v)", removed, err)
+	}
+
+	// expires while spooled
+	s.Append(Record{Name: "aging", Timestamp: time.Now().Add(-time.Hour + 50*time.Millisecond)})
+	s.Append(Record{Name: "new", Timestamp: time.Now()})
+	time.Sleep(100 * time.Millisecond)
+
+	r, expired, err := s.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].Name != "aging" {
+		t.Fatalf("aging record should expire, got %+v", expired)
+	}
+	if r == nil || r.Name != "new" {
+		t.Fatalf("new record expected, got %+v", r)
+	}
+}
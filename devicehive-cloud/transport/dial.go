@@ -0,0 +1,98 @@
+package transport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Dial opens TCP connection to the address (host:port) through the proxy
+// selected for it (as for https:// URL), HTTP proxies are asked to open
+// a tunnel with CONNECT request, SOCKS5 proxies are used directly
+func (t *Transport) Dial(addr string, timeout time.Duration) (net.Conn, error) {
+	var proxyURL *url.URL
+	if t.Proxy != nil {
+		req := &http.Request{URL: &url.URL{Scheme: "https", Host: addr}, Header: make(http.Header)}
+		var err error
+		if proxyURL, err = t.Proxy(req); err != nil {
+			return nil, err
+		}
+	}
+
+	d := &net.Dialer{Timeout: timeout}
+	if proxyURL == nil {
+		return d.Dial("tcp", addr)
+	}
+
+	switch proxyURL.Scheme {
+	case "socks5":
+		p, err := proxy.FromURL(proxyURL, d)
+		if err != nil {
+			return nil, err
+		}
+		return p.Dial("tcp", addr)
+	case "http", "https":
+		return connectTunnel(d, proxyURL, addr)
+	}
+	return nil, fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+}
+
+// open tunnel through HTTP proxy, HTTPS proxy is verified with system roots
+func connectTunnel(d *net.Dialer, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if len(proxyURL.Port()) == 0 {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+
+	conn, err := d.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if d.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.Timeout))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the server does not send anything until the client speaks,
+	// so nothing is buffered after the response
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close() // the body is not read
+		return nil, fmt.Errorf("proxy %q refused tunnel to %q: %s", proxyURL.Host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
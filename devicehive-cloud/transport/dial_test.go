@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+const testTimeout = 5 * time.Second
+
+// start echo server, returns its address
+func startEcho(t *testing.T) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// start HTTP proxy supporting CONNECT only, requests with other
+// credentials than expected (if any) are refused
+func startConnectProxy(t *testing.T, credentials string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if len(credentials) != 0 && r.Header.Get("Proxy-Authorization") != "Basic "+credentials {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			defer target.Close()
+			io.Copy(target, conn)
+		}()
+		defer conn.Close()
+		io.Copy(conn, target)
+	}))
+}
+
+// check the connection is open to echo server
+func echo(t *testing.T, conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "ping\n" {
+		t.Errorf("unexpected echo %q", line)
+	}
+}
+
+// proxy function always selecting the URL
+func fixedProxy(t *testing.T, proxyURL string) Proxy {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(*http.Request) (*url.URL, error) { return u, nil }
+}
+
+func TestDialDirect(t *testing.T) {
+	addr, stop := startEcho(t)
+	defer stop()
+
+	for _, proxy := range []Proxy{nil, func(*http.Request) (*url.URL, error) { return nil, nil }} {
+		conn, err := New(nil, proxy).Dial(addr, testTimeout)
+		if err != nil {
+			t.Fatal(err)
+		}
+		echo(t, conn)
+		conn.Close()
+	}
+}
+
+func TestDialConnect(t *testing.T) {
+	addr, stop := startEcho(t)
+	defer stop()
+
+	// "user:secret" in base64
+	proxy := startConnectProxy(t, "dXNlcjpzZWNyZXQ=")
+	defer proxy.Close()
+	u, _ := url.Parse(proxy.URL)
+
+	conn, err := New(nil, fixedProxy(t, "http://user:secret@"+u.Host)).Dial(addr, testTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo(t, conn)
+	conn.Close()
+
+	if _, err = New(nil, fixedProxy(t, proxy.URL)).Dial(addr, testTimeout); err == nil {
+		t.Error("tunnel is opened without credentials")
+	}
+	if _, err = New(nil, fixedProxy(t, "ftp://"+u.Host)).Dial(addr, testTimeout); err == nil {
+		t.Error("unsupported proxy scheme is accepted")
+	}
+}
@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyFunc builds proxy selection function, if no proxy URL is specified
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables are used
+// (with configured credentials unless the variable has its own)
+func ProxyFunc(c conf.ProxyConf) (Proxy, error) {
+	env := httpproxy.FromEnvironment()
+	if len(c.URL) == 0 {
+		proxy := fromConfig(env)
+		if len(c.Username) == 0 {
+			return proxy, nil
+		}
+		return func(r *http.Request) (*url.URL, error) {
+			u, err := proxy(r)
+			if u == nil || u.User != nil {
+				return u, err
+			}
+			withUser := *u // copied per request, u is cached by httpproxy
+			withUser.User = url.UserPassword(c.Username, c.Password)
+			return &withUser, err
+		}, nil
+	}
+
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	if len(c.Username) != 0 {
+		u.User = url.UserPassword(c.Username, c.Password)
+	}
+
+	noProxy := c.NoProxy
+	if len(noProxy) == 0 {
+		noProxy = env.NoProxy
+	}
+	return fromConfig(&httpproxy.Config{
+		HTTPProxy:  u.String(),
+		HTTPSProxy: u.String(),
+		NoProxy:    noProxy,
+	}), nil
+}
+
+// adapt proxy configuration to HTTP transport
+func fromConfig(c *httpproxy.Config) Proxy {
+	proxy := c.ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxy(r.URL)
+	}
+}
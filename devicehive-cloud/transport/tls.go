@@ -0,0 +1,109 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+)
+
+var (
+	errPinMismatch = errors.New("server certificate does not match the pin")
+)
+
+// supported minimum TLS versions
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig builds TLS client configuration,
+// returns nil if no TLS settings are specified
+func TLSConfig(c conf.TLSConf) (*tls.Config, error) {
+	if c == (conf.TLSConf{}) {
+		return nil, nil // defaults
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}
+
+	if len(c.CAFile) != 0 {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %q", c.CAFile)
+		}
+	}
+
+	if len(c.CertFile) != 0 || len(c.KeyFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(c.MinVersion) != 0 {
+		version, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported TLS version %q", c.MinVersion)
+		}
+		cfg.MinVersion = version
+	}
+
+	if len(c.PinSHA256) != 0 {
+		pin, err := base64.StdEncoding.DecodeString(c.PinSHA256)
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid SHA-256 pin %q", c.PinSHA256)
+		}
+		cfg.VerifyPeerCertificate = verifyPin(pin)
+	}
+
+	return cfg, nil
+}
+
+// check that a certificate of the verified chains has pinned public key,
+// only the server certificate is checked if verification is skipped:
+// other certificates sent by the server are not proven to belong to it
+func verifyPin(pin []byte) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+		if len(verifiedChains) == 0 {
+			if len(rawCerts) == 0 {
+				return errPinMismatch
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			if !pinned(cert, pin) {
+				return errPinMismatch
+			}
+			return nil
+		}
+
+		for _, chain := range verifiedChains {
+			for _, cert := range chain {
+				if pinned(cert, pin) {
+					return nil
+				}
+			}
+		}
+		return errPinMismatch
+	}
+}
+
+// check public key of the certificate
+func pinned(cert *x509.Certificate, pin []byte) bool {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return bytes.Equal(sum[:], pin)
+}
@@ -0,0 +1,161 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+)
+
+// start TLS server, returns its URL and the public key pin
+func startTLSServer(t *testing.T) (*httptest.Server, string) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	sum := sha256.Sum256(s.Certificate().RawSubjectPublicKeyInfo)
+	return s, base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// send GET request through dedicated transport
+func get(c conf.TLSConf, url string) error {
+	cfg, err := TLSConfig(c)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Transport: New(cfg, nil).HTTP}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func TestPin(t *testing.T) {
+	s, pin := startTLSServer(t)
+	defer s.Close()
+
+	other := base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+	tests := []struct {
+		title string
+		conf  conf.TLSConf
+		ok    bool
+	}{
+		{"self-signed", conf.TLSConf{}, false},
+		{"pin", conf.TLSConf{PinSHA256: pin, InsecureSkipVerify: true}, true},
+		{"wrong pin", conf.TLSConf{PinSHA256: other, InsecureSkipVerify: true}, false},
+		{"insecure", conf.TLSConf{InsecureSkipVerify: true}, true},
+	}
+
+	for _, test := range tests {
+		err := get(test.conf, s.URL)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%s: unexpected result (error: %v)", test.title, err)
+		}
+	}
+}
+
+// create self-signed certificate for 127.0.0.1
+func selfSigned(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "attacker"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestPinChain(t *testing.T) {
+	s, pin := startTLSServer(t)
+	defer s.Close()
+
+	// untrusted server sends the pinned certificate after its own one
+	cert := selfSigned(t)
+	cert.Certificate = append(cert.Certificate, s.Certificate().Raw)
+	mitm := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	mitm.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	mitm.StartTLS()
+	defer mitm.Close()
+
+	if err := get(conf.TLSConf{PinSHA256: pin, InsecureSkipVerify: true}, mitm.URL); err == nil {
+		t.Error("pinned certificate of the chain is accepted for other server")
+	}
+}
+
+func TestCAFile(t *testing.T) {
+	s, pin := startTLSServer(t)
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	buf := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	if err = ioutil.WriteFile(caFile, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = get(conf.TLSConf{CAFile: caFile}, s.URL); err != nil {
+		t.Errorf("server is not trusted (error: %s)", err)
+	}
+	if err = get(conf.TLSConf{CAFile: caFile, PinSHA256: pin, MinVersion: "1.2"}, s.URL); err != nil {
+		t.Errorf("server is not trusted with pin (error: %s)", err)
+	}
+
+	empty := filepath.Join(dir, "empty.pem")
+	if err = ioutil.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = TLSConfig(conf.TLSConf{CAFile: empty}); err == nil {
+		t.Error("empty CA file is accepted")
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	tests := []conf.TLSConf{
+		{MinVersion: "2.0"},
+		{PinSHA256: "not base64"},
+		{PinSHA256: base64.StdEncoding.EncodeToString([]byte("short"))},
+		{CAFile: "/nonexistent/ca.pem"},
+		{CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"},
+	}
+	for _, c := range tests {
+		if _, err := TLSConfig(c); err == nil {
+			t.Errorf("%+v is accepted", c)
+		}
+	}
+
+	if cfg, err := TLSConfig(conf.TLSConf{}); cfg != nil || err != nil {
+		t.Errorf("unexpected default configuration %+v (error: %v)", cfg, err)
+	}
+}
@@ -0,0 +1,91 @@
+package transport
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+)
+
+// Proxy selects proxy of the request, nil URL means direct connection
+type Proxy func(r *http.Request) (*url.URL, error)
+
+// default settings, captured before default transports are replaced
+var (
+	baseTransport, _ = http.DefaultTransport.(*http.Transport)
+	baseDialer       = *websocket.DefaultDialer
+)
+
+// Transport is a dedicated HTTP transport and websocket dialer
+// with TLS and proxy settings, created per connection
+type Transport struct {
+	TLS    *tls.Config // nil means default settings
+	Proxy  Proxy
+	HTTP   *http.Transport
+	Dialer *websocket.Dialer
+}
+
+// New creates transport, nil TLS configuration means default settings
+func New(cfg *tls.Config, proxy Proxy) *Transport {
+	t := &Transport{TLS: cfg, Proxy: proxy}
+
+	if baseTransport != nil {
+		t.HTTP = baseTransport.Clone()
+	} else {
+		t.HTTP = new(http.Transport)
+	}
+	t.HTTP.TLSClientConfig = cfg
+	t.HTTP.Proxy = proxy
+
+	dialer := baseDialer
+	dialer.TLSClientConfig = cfg
+	dialer.Proxy = proxy
+	t.Dialer = &dialer
+	return t
+}
+
+// Close closes idle connections
+func (t *Transport) Close() {
+	t.HTTP.CloseIdleConnections()
+}
+
+// routes requests of the default HTTP transport
+type route struct {
+	current atomic.Value // *Transport
+}
+
+func (r *route) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.current.Load().(*Transport).HTTP.RoundTrip(req)
+}
+
+var (
+	defaultRoute     = new(route)
+	defaultRouteOnce sync.Once
+	defaultMutex     sync.Mutex // serializes swaps
+)
+
+// UseByDefault makes default HTTP transport and websocket dialer use the transport,
+// it is required by libraries which connect with default settings only.
+// HTTP requests in progress are not affected. The websocket dialer is read
+// by such libraries without synchronization, so the caller should make sure
+// connections of the previous transport are closed and nothing is dialing
+// (ex: it is called when the previous session is over)
+func UseByDefault(t *Transport) {
+	defaultMutex.Lock()
+	defer defaultMutex.Unlock()
+
+	defaultRouteOnce.Do(func() {
+		// the first transport is used until default one is replaced
+		defaultRoute.current.Store(t)
+		http.DefaultTransport = defaultRoute
+	})
+
+	if prev, ok := defaultRoute.current.Load().(*Transport); ok && prev != t {
+		prev.Close()
+	}
+	defaultRoute.current.Store(t)
+	websocket.DefaultDialer = t.Dialer
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/devicehive/IoT-framework/devicehive-cloud/conf"
+	"github.com/devicehive/IoT-framework/devicehive-cloud/schema"
+	log "github.com/devicehive/IoT-framework/godbus-helpers/logging"
+)
+
+const (
+	// non-conforming payloads are only logged
+	schemaValidationWarn = "warn"
+)
+
+// JSON Schema validation of outgoing payloads
+type payloadValidator struct {
+	sync.RWMutex
+	notifications *schema.Validator
+	results       *schema.Validator
+	warnOnly      bool
+}
+
+// load notification and command result schemas
+func newPayloadValidator(config conf.Conf) (*payloadValidator, error) {
+	v := &payloadValidator{warnOnly: config.SchemaValidation == schemaValidationWarn}
+
+	var err error
+	if v.notifications, err = schema.Load(config.NotificationSchemas); err != nil {
+		return nil, fmt.Errorf("notification %s", err)
+	}
+	if v.results, err = schema.Load(config.CommandResultSchemas); err != nil {
+		return nil, fmt.Errorf("command result %s", err)
+	}
+	return v, nil
+}
+
+// replace schemas and mode with the new ones
+func (v *payloadValidator) update(other *payloadValidator) {
+	v.Lock()
+	defer v.Unlock()
+	v.notifications = other.notifications
+	v.results = other.results
+	v.warnOnly = other.warnOnly
+}
+
+// check schemas or mode differ from the other ones
+func (v *payloadValidator) changed(other *payloadValidator) bool {
+	v.RLock()
+	defer v.RUnlock()
+	return v.warnOnly != other.warnOnly ||
+		v.notifications.Digest() != other.notifications.Digest() ||
+		v.results.Digest() != other.results.Digest()
+}
+
+// check notification parameters
+func (v *payloadValidator) notification(name string, dat interface{}) error {
+	v.RLock()
+	defer v.RUnlock()
+	if err := v.notifications.Validate(name, dat); err != nil {
+		return v.failed(fmt.Errorf("invalid parameters of notification %q: %s", name, err))
+	}
+	return nil
+}
+
+// check command result, command name is required to find the schema
+func (v *payloadValidator) commandResult(name string, dat interface{}) error {
+	v.RLock()
+	defer v.RUnlock()
+	if err := v.results.Validate(name, dat); err != nil {
+		return v.failed(fmt.Errorf("invalid result of command %q: %s", name, err))
+	}
+	return nil
+}
+
+// report failure according to the mode, should be called under lock
+func (v *payloadValidator) failed(err error) error {
+	if v.warnOnly {
+		log.Warnf("%s", err)
+		return nil
+	}
+	return err
+}
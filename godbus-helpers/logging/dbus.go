@@ -0,0 +1,40 @@
+package logging
+
+import (
+	"github.com/godbus/dbus"
+	"github.com/godbus/dbus/introspect"
+)
+
+// DBusInterface is exported next to the daemon object
+const DBusInterface = "com.devicehive.Logging"
+
+// Control changes levels at runtime over D-Bus
+type Control struct{}
+
+// SetLogLevel sets level of the component (the default level if empty),
+// empty level removes the component override
+func (Control) SetLogLevel(component, level string) *dbus.Error {
+	if err := SetLevel(component, level); err != nil {
+		return dbus.NewError(DBusInterface+".Error", []interface{}{err.Error()})
+	}
+	Infof("logging level of %q is set to %q", component, level)
+	return nil
+}
+
+// GetLogLevels returns the default level (with empty key) and component overrides
+func (Control) GetLogLevels() (map[string]string, *dbus.Error) {
+	return Levels(), nil
+}
+
+// Export exports Control on the path
+func Export(conn *dbus.Conn, path dbus.ObjectPath) error {
+	return conn.Export(Control{}, path, DBusInterface)
+}
+
+// Introspection describes DBusInterface (to be added to the object node)
+func Introspection() introspect.Interface {
+	return introspect.Interface{
+		Name:    DBusInterface,
+		Methods: introspect.Methods(Control{}),
+	}
+}
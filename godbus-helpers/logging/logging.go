@@ -0,0 +1,337 @@
+// Package logging is a structured logger shared by the framework daemons.
+//
+// Records are written as logfmt (default) or JSON lines with service,
+// component and custom fields (ex: mac, command, msgId). Level is set
+// for all components and may be overridden per component.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Level int
+
+const (
+	TRACE Level = iota
+	DEBUG
+	INFO
+	WARN
+	ERROR
+	FATAL
+)
+
+var levelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+func (l Level) String() string {
+	if l < TRACE || l > FATAL {
+		return strconv.Itoa(int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses level name ("warning" is accepted as well)
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "warning" {
+		return WARN, nil
+	}
+	for i, n := range levelNames {
+		if n == name {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown logging level %q", name)
+}
+
+// shared state of all loggers
+var (
+	mutex        sync.RWMutex
+	service      string
+	jsonFormat   bool
+	output       io.Writer = os.Stderr
+	defaultLevel           = INFO
+	levels                 = make(map[string]Level) // by component
+	exit                   = os.Exit
+)
+
+// SetService sets name of the daemon added to all records
+func SetService(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	service = name
+}
+
+// SetFormat selects "logfmt" (default) or "json" output
+func SetFormat(format string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch format {
+	case "", "logfmt":
+		jsonFormat = false
+	case "json":
+		jsonFormat = true
+	default:
+		return fmt.Errorf("unknown logging format %q", format)
+	}
+	return nil
+}
+
+// SetOutput redirects records (stderr by default)
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+}
+
+// SetLevel sets level of the component, empty component sets the default level,
+// empty level removes the component override
+func SetLevel(component, level string) error {
+	var l Level
+	if len(level) != 0 || len(component) == 0 {
+		var err error
+		if l, err = ParseLevel(level); err != nil {
+			return err
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	switch {
+	case len(component) == 0:
+		defaultLevel = l
+	case len(level) == 0:
+		delete(levels, component)
+	default:
+		levels[component] = l
+	}
+	return nil
+}
+
+// SetLevelByName sets the default level, unknown names are ignored
+func SetLevelByName(level string) {
+	if err := SetLevel("", level); err != nil {
+		Warnf("%s", err)
+	}
+}
+
+// SetLevels replaces the default level and all component overrides
+func SetLevels(level string, components map[string]string) error {
+	def, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	res := make(map[string]Level, len(components))
+	for c, name := range components {
+		if res[c], err = ParseLevel(name); err != nil {
+			return fmt.Errorf("%s: %s", c, err)
+		}
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	defaultLevel = def
+	levels = res
+	return nil
+}
+
+// ParseSpec parses "level,component=level,..." (ex: "info,gatt=debug")
+func ParseSpec(spec string) (level string, components map[string]string, err error) {
+	level = "info"
+	components = make(map[string]string)
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		if i := strings.Index(s, "="); i < 0 {
+			level = s
+		} else {
+			components[s[:i]] = s[i+1:]
+		}
+	}
+	if _, err = ParseLevel(level); err != nil {
+		return
+	}
+	for c, l := range components {
+		if _, err = ParseLevel(l); err != nil {
+			err = fmt.Errorf("%s: %s", c, err)
+			return
+		}
+	}
+	return
+}
+
+// Configure sets service name, format and levels spec (see ParseSpec)
+func Configure(service, format, spec string) error {
+	level, components, err := ParseSpec(spec)
+	if err != nil {
+		return err
+	}
+	if err = SetFormat(format); err != nil {
+		return err
+	}
+	SetService(service)
+	return SetLevels(level, components)
+}
+
+// Levels returns the default level (with empty key) and component overrides
+func Levels() map[string]string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	res := make(map[string]string, len(levels)+1)
+	res[""] = defaultLevel.String()
+	for c, l := range levels {
+		res[c] = l.String()
+	}
+	return res
+}
+
+// Logger writes records of a component with the attached fields
+type Logger struct {
+	component string
+	fields    []interface{} // key, value pairs
+}
+
+var root = &Logger{}
+
+// Component creates logger of the subsystem
+func Component(name string) *Logger {
+	return &Logger{component: name}
+}
+
+// With creates logger with additional key, value fields
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, nil)
+	}
+	return &Logger{component: l.component, fields: fields}
+}
+
+// Enabled checks if records of the level are written
+func (l *Logger) Enabled(level Level) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return level >= l.level()
+}
+
+// should be called under lock
+func (l *Logger) level() Level {
+	if level, ok := levels[l.component]; ok {
+		return level
+	}
+	return defaultLevel
+}
+
+func (l *Logger) Tracef(format string, args ...interface{}) { l.log(TRACE, format, args) }
+func (l *Logger) Debugf(format string, args ...interface{}) { l.log(DEBUG, format, args) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.log(INFO, format, args) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.log(WARN, format, args) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.log(ERROR, format, args) }
+
+// Fatalf writes the record and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(FATAL, format, args)
+	exit(1)
+}
+
+// the default component
+func Tracef(format string, args ...interface{}) { root.log(TRACE, format, args) }
+func Debugf(format string, args ...interface{}) { root.log(DEBUG, format, args) }
+func Infof(format string, args ...interface{})  { root.log(INFO, format, args) }
+func Warnf(format string, args ...interface{})  { root.log(WARN, format, args) }
+func Errorf(format string, args ...interface{}) { root.log(ERROR, format, args) }
+func Fatalf(format string, args ...interface{}) { root.Fatalf(format, args...) }
+
+// write the record
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	now := time.Now()
+
+	mutex.RLock()
+	enabled := level >= l.level() || level == FATAL
+	svc, asJSON, w := service, jsonFormat, output
+	mutex.RUnlock()
+	if !enabled {
+		return
+	}
+
+	kv := []interface{}{
+		"time", now.UTC().Format(time.RFC3339Nano),
+		"level", level.String(),
+	}
+	if len(svc) != 0 {
+		kv = append(kv, "service", svc)
+	}
+	if len(l.component) != 0 {
+		kv = append(kv, "component", l.component)
+	}
+	kv = append(kv, "msg", fmt.Sprintf(format, args...))
+	kv = append(kv, l.fields...)
+
+	var buf bytes.Buffer
+	if asJSON {
+		writeJSON(&buf, kv)
+	} else {
+		writeLogfmt(&buf, kv)
+	}
+
+	// lines are not interleaved
+	mutex.Lock()
+	w.Write(buf.Bytes())
+	mutex.Unlock()
+}
+
+// {"key": value, ...} keeping the order
+func writeJSON(buf *bytes.Buffer, kv []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(kv); i += 2 {
+		if i != 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(kv[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+
+		v := kv[i+1]
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(v))
+		}
+		buf.Write(value)
+	}
+	buf.WriteString("}\n")
+}
+
+// key=value key="quoted value" ...
+func writeLogfmt(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		if i != 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(kv[i]))
+		buf.WriteByte('=')
+
+		s := ""
+		if kv[i+1] != nil {
+			s = fmt.Sprint(kv[i+1])
+		}
+		if len(s) == 0 || strings.ContainsAny(s, " =\"\t\r\n\\") {
+			s = strconv.Quote(s)
+		}
+		buf.WriteString(s)
+	}
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,159 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// capture records of the test, shared state is restored by the returned function
+func capture(t *testing.T, format string) (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	prev := output
+	if err := SetFormat(format); err != nil {
+		t.Fatal(err)
+	}
+	SetOutput(&buf)
+	SetService("")
+	return &buf, func() {
+		SetFormat("")
+		SetOutput(prev)
+		SetService("")
+		SetLevels("info", nil)
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec       string
+		level      string
+		components map[string]string
+		ok         bool
+	}{
+		{"", "info", map[string]string{}, true},
+		{"debug", "debug", map[string]string{}, true},
+		{"warn, gatt=debug ,mqtt=trace", "warn", map[string]string{"gatt": "debug", "mqtt": "trace"}, true},
+		{"gatt=error", "info", map[string]string{"gatt": "error"}, true},
+		{"verbose", "", nil, false},
+		{"info,gatt=verbose", "", nil, false},
+	}
+
+	for _, test := range tests {
+		level, components, err := ParseSpec(test.spec)
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("%q: unexpected result (error: %v)", test.spec, err)
+			continue
+		}
+		if !test.ok {
+			continue
+		}
+		if level != test.level || !reflect.DeepEqual(components, test.components) {
+			t.Errorf("%q: parsed as %q %v, expected: %q %v", test.spec, level, components, test.level, test.components)
+		}
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	_, restore := capture(t, "")
+	defer restore()
+
+	if err := Configure("test", "", "warn,gatt=debug"); err != nil {
+		t.Fatal(err)
+	}
+	gatt, other := Component("gatt"), Component("other")
+	if !gatt.Enabled(DEBUG) || gatt.Enabled(TRACE) {
+		t.Error("component level is not applied")
+	}
+	if other.Enabled(INFO) || !other.Enabled(WARN) {
+		t.Error("default level is not applied")
+	}
+
+	// empty level removes the override, empty component sets the default
+	if err := SetLevel("gatt", ""); err != nil {
+		t.Fatal(err)
+	}
+	if gatt.Enabled(INFO) {
+		t.Error("component override is not removed")
+	}
+	if err := SetLevel("", "warning"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetLevel("", ""); err == nil {
+		t.Error("empty default level is accepted")
+	}
+	if err := SetLevel("gatt", "verbose"); err == nil {
+		t.Error("unknown level is accepted")
+	}
+
+	// invalid levels do not change anything
+	if err := SetLevels("info", map[string]string{"gatt": "verbose"}); err == nil {
+		t.Error("unknown component level is accepted")
+	}
+	if expected := map[string]string{"": "warn"}; !reflect.DeepEqual(Levels(), expected) {
+		t.Errorf("levels %v, expected: %v", Levels(), expected)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	buf, restore := capture(t, "logfmt")
+	defer restore()
+
+	SetService("ble")
+	Component("gatt").With("mac", "00:11:22", "note", `say "hi"`, "empty", "", "odd").
+		Infof("connected to %s", "device")
+
+	line := buf.String()
+	for _, s := range []string{
+		" level=info service=ble component=gatt ",
+		` msg="connected to device" mac=00:11:22 note="say \"hi\"" empty="" odd=""` + "\n",
+	} {
+		if !strings.Contains(line, s) {
+			t.Errorf("%q does not contain %q", line, s)
+		}
+	}
+	if !strings.HasPrefix(line, "time=") {
+		t.Errorf("%q does not start with time", line)
+	}
+
+	// disabled records are not written
+	buf.Reset()
+	Debugf("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("debug record is written: %q", buf.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buf, restore := capture(t, "json")
+	defer restore()
+
+	Component("mqtt").With("count", 3, "err", errors.New("failed")).Warnf("line\nbreak")
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("%q is not JSON (error: %s)", buf.String(), err)
+	}
+	delete(record, "time")
+	expected := map[string]interface{}{
+		"level":     "warn",
+		"component": "mqtt",
+		"msg":       "line\nbreak",
+		"count":     float64(3),
+		"err":       "failed",
+	}
+	if !reflect.DeepEqual(record, expected) {
+		t.Errorf("record %v, expected: %v", record, expected)
+	}
+
+	// keys keep the order
+	if s := buf.String(); strings.Index(s, `"level"`) > strings.Index(s, `"msg"`) {
+		t.Errorf("fields are reordered: %q", s)
+	}
+
+	if err := SetFormat("xml"); err == nil {
+		t.Error("unknown format is accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"strings"
+	"sync"
+)
+
+// Ring keeps the last records in memory, use it with SetOutput
+// (ex: io.MultiWriter(os.Stderr, ring)) to tail recent logs
+type Ring struct {
+	mutex sync.Mutex
+	lines []string
+	next  int  // position of the next line
+	full  bool // lines are overwritten
+}
+
+// NewRing creates buffer of size lines
+func NewRing(size int) *Ring {
+	if size < 1 {
+		size = 1
+	}
+	return &Ring{lines: make([]string, size)}
+}
+
+// Write stores records, one per line
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		r.lines[r.next] = line
+		if r.next++; r.next == len(r.lines) {
+			r.next, r.full = 0, true
+		}
+	}
+	return len(p), nil
+}
+
+// Tail returns the last n lines (all if n <= 0), the oldest first
+func (r *Ring) Tail(n int) []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	if n <= 0 || n > count {
+		n = count
+	}
+
+	res := make([]string, n)
+	for i := range res {
+		j := (r.next - n + i + len(r.lines)) % len(r.lines)
+		res[i] = r.lines[j]
+	}
+	return res
+}